@@ -0,0 +1,189 @@
+package main
+
+// The config file, ~/.config/evsniff/config.toml, e.g.
+//
+//	[aliases]
+//	pedal = "vendor=0c45 product=7403"
+//	kbd = "/dev/input/by-id/usb-Topre_Realforce-event-kbd"
+//
+//	[profiles.gaming]
+//	flags = ["-s", "--global-mods"]
+//	filters = ["@kbd", "@pedal"]
+//
+// Aliases label the devices matching a FILTER, and can be used as FILTERs with @NAME.
+// "evsniff -P gaming" runs a profile: its flags are inserted in place of -P, and its
+// filters are added to the command line FILTERs.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+type config struct {
+	Aliases  map[string]string
+	Profiles map[string]configProfile
+
+	aliasOrder []string // Aliases, in the order in the file.
+}
+
+type configProfile struct {
+	Flags   []string
+	Filters []string
+}
+
+type configAlias struct {
+	name string
+	sel  evutil.Selector
+}
+
+var (
+	configAliases []configAlias
+	deviceLabels  = make(map[string]string) // Path -> alias name of the selected devices.
+
+	// configErr is why the config file can't be used. Only the profiles and the aliases need it,
+	// so it's an error only for them, and a warning otherwise.
+	configErr error
+)
+
+// configPath returns the path of the config file, in $XDG_CONFIG_HOME or ~/.config.
+func configPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "evsniff", "config.toml")
+}
+
+// loadConfig reads the config file. It returns an empty config if there's no config file.
+func loadConfig() (*config, error) {
+	cfg := &config{}
+	file := configPath()
+	if file == "" {
+		return cfg, nil
+	}
+	md, err := toml.DecodeFile(file, cfg)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", file, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("%s: unknown key %q", file, undecoded[0].String())
+	}
+	for _, key := range md.Keys() {
+		if len(key) == 2 && key[0] == "aliases" {
+			cfg.aliasOrder = append(cfg.aliasOrder, key[1])
+		}
+	}
+	return cfg, nil
+}
+
+// loadConfigAndAliases reads the config file and parses its aliases. If the config file is
+// broken, it sets configErr and returns an empty config.
+func loadConfigAndAliases() *config {
+	configAliases, configErr = nil, nil
+	cfg, err := loadConfig()
+	if err == nil {
+		_, err = parseAliases(cfg)
+	}
+	configErr = err
+	if err != nil {
+		configAliases = nil
+		return &config{}
+	}
+	return cfg
+}
+
+// warnConfigErr prints configErr, if the config file is broken but wasn't needed.
+func warnConfigErr() {
+	if configErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", configErr)
+	}
+}
+
+// parseAliases parses the FILTERs of the aliases.
+func parseAliases(cfg *config) ([]configAlias, error) {
+	ret := make([]configAlias, 0, len(cfg.aliasOrder))
+	for _, name := range cfg.aliasOrder {
+		sel, err := parseFilter(cfg.Aliases[name])
+		if err != nil {
+			return nil, fmt.Errorf("alias %s: %w", name, err)
+		}
+		ret = append(ret, configAlias{name, sel})
+		configAliases = ret // So later aliases can refer to this one.
+	}
+	return ret, nil
+}
+
+// findAlias returns the alias with name.
+func findAlias(name string) (configAlias, bool) {
+	for _, a := range configAliases {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return configAlias{}, false
+}
+
+// expandProfiles removes "-P NAME" and "--profile NAME" from args, and adds the flags of
+// the profile before the other flags, so they can be overridden, and its filters after the
+// other FILTERs.
+func expandProfiles(args []string, cfg *config) ([]string, error) {
+	var flags, rest, filters []string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		var name string
+		switch {
+		case arg == "-P" || arg == "--profile":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a profile name", arg)
+			}
+			i++
+			name = args[i]
+		case strings.HasPrefix(arg, "--profile="):
+			name = strings.TrimPrefix(arg, "--profile=")
+		case strings.HasPrefix(arg, "-P"):
+			name = strings.TrimPrefix(arg, "-P")
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		p, ok := cfg.Profiles[name]
+		if !ok && configErr != nil {
+			return nil, configErr
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		flags = append(flags, p.Flags...)
+		filters = append(filters, p.Filters...)
+	}
+	ret := append([]string{args[0]}, flags...)
+	ret = append(ret, rest...)
+	return append(ret, filters...), nil
+}
+
+// labelDevice remembers the name of the first alias matching d, for the device headers.
+func labelDevice(d evutil.Device) {
+	delete(deviceLabels, d.Path())
+	for _, a := range configAliases {
+		if evutil.Matches(evutil.NewCombinedSelector().Add(a.sel), d) {
+			deviceLabels[d.Path()] = a.name
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+func TestMain(m *testing.M) {
+	// Don't read the config file of the user.
+	dir, err := os.MkdirTemp("", "evsniff-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+const testConfig = `
+[aliases]
+pedal = "vendor=0c45 product=7403"
+kbd = "Realforce & !consumer"
+both = "@kbd | @pedal"
+
+[profiles.gaming]
+flags = ["-s", "--global-mods"]
+filters = ["@kbd", "@pedal"]
+
+[profiles.quiet]
+flags = ["-R", "-A"]
+`
+
+func writeTestConfig(t *testing.T, content string) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "evsniff"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "evsniff", "config.toml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandProfiles(t *testing.T) {
+	writeTestConfig(t, testConfig)
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pedal", "kbd", "both"}; !slices.Equal(cfg.aliasOrder, want) {
+		t.Errorf("aliasOrder = %v, want %v", cfg.aliasOrder, want)
+	}
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"evsniff", "-v", "-P", "gaming"}, []string{"evsniff", "-s", "--global-mods", "-v", "@kbd", "@pedal"}},
+		{[]string{"evsniff", "-Pquiet", "--profile=gaming", "logitech"}, []string{"evsniff", "-R", "-A", "-s", "--global-mods", "logitech", "@kbd", "@pedal"}},
+		{[]string{"evsniff", "--", "-P"}, []string{"evsniff", "--", "-P"}},
+	}
+	for _, tt := range tests {
+		got, err := expandProfiles(tt.args, cfg)
+		if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.args, got, tt.want)
+		}
+	}
+	for _, args := range [][]string{{"evsniff", "-P", "nope"}, {"evsniff", "--profile"}} {
+		if _, err := expandProfiles(args, cfg); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestConfigAliases(t *testing.T) {
+	writeTestConfig(t, testConfig)
+	origExit := osExit
+	defer func() { osExit = origExit }()
+	exitCode := -1
+	osExit = func(code int) { exitCode = code }
+
+	_, sel := parseArgs([]string{"evsniff", "-P", "gaming"})
+	if exitCode != -1 {
+		t.Fatalf("exit code = %d", exitCode)
+	}
+	if !*simple || !*globalMods {
+		t.Errorf("the flags of the profile aren't set")
+	}
+
+	pedal := &sysfsDevice{path: "/dev/input/event7", name: "PCsensor FootSwitch"}
+	pedal.id.Vendor, pedal.id.Product = 0x0c45, 0x7403
+	kbd := &sysfsDevice{path: "/dev/input/event3", name: "Topre Realforce"}
+	consumer := &sysfsDevice{path: "/dev/input/event4", name: "Topre Realforce Consumer Control"}
+	for d, want := range map[*sysfsDevice]string{pedal: "pedal", kbd: "kbd", consumer: ""} {
+		if got := evutil.Matches(sel, d); got != (want != "") {
+			t.Errorf("%s: selected = %v", d.name, got)
+		}
+		clear(deviceLabels)
+		labelDevice(d)
+		if got := deviceLabels[d.path]; got != want {
+			t.Errorf("%s: label = %q, want %q", d.name, got, want)
+		}
+	}
+
+	clear(deviceLabels)
+	labelDevice(pedal)
+	stdout, _, _ := captureOutput(func() {
+		printDeviceHeader(&noColorizer{}, pedal.path, evdev.InputID{Vendor: 0x0c45, Product: 0x7403}, pedal.name)
+		printDeviceHeader(&noColorizer{}, kbd.path, evdev.InputID{}, kbd.name)
+	})
+	want := "# pedal (/dev/input/event7)\n# From device [v0000 p0000]: Topre Realforce (/dev/input/event3)\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	clear(deviceLabels)
+}
+
+func TestConfigErrors(t *testing.T) {
+	origExit := osExit
+	defer func() { osExit = origExit }()
+
+	for _, content := range []string{
+		"[aliases]\nbad = \"@nope\"\n",
+		"[aliases]\nbad = \"vendor=xyz\"\n",
+		"[aliasses]\nfoo = \"bar\"\n",
+		"[aliases\n",
+	} {
+		writeTestConfig(t, content)
+		// The config file is only needed for the profiles and the aliases.
+		for _, tt := range []struct {
+			args   []string
+			code   int
+			prefix string
+		}{
+			{[]string{"evsniff"}, -1, "Warning: "},
+			{[]string{"evsniff", "keyboard"}, -1, "Warning: "},
+			{[]string{"evsniff", "-P", "gaming"}, 2, "Error: "},
+			{[]string{"evsniff", "@kbd"}, 2, "Error: "},
+		} {
+			exitCode := -1
+			osExit = func(code int) { exitCode = code }
+			_, stderr, _ := captureOutput(func() {
+				parseArgs(tt.args)
+			})
+			if exitCode != tt.code || !strings.HasPrefix(stderr, tt.prefix) || strings.Count(stderr, "\n") != 1 {
+				t.Errorf("%q %v: exit code = %d, stderr = %q", content, tt.args, exitCode, stderr)
+			}
+		}
+	}
+	resetFlags()
+}
@@ -18,7 +18,9 @@ import (
 	"unsafe"
 
 	"github.com/holoplot/go-evdev"
+	"github.com/maruel/natural"
 	"github.com/omakoto/evsniff-go/evutil"
+	"github.com/omakoto/go-common/src/utils"
 )
 
 var (
@@ -49,14 +51,6 @@ func (r *rawDevice) Name() (string, error) {
 	return r.name, nil
 }
 
-func doRawIoctl(fd uintptr, code uint32, ptr unsafe.Pointer) error {
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(code), uintptr(ptr))
-	if errno != 0 {
-		return errno
-	}
-	return nil
-}
-
 func getRawDeviceName(fd uintptr) (string, error) {
 	var nameBytes [256]byte
 	code := (uint32(2) << 30) | (uint32(256) << 16) | (uint32('E') << 8) | uint32(0x06)
@@ -114,7 +108,7 @@ func printActiveKeysFast(sel evutil.Selector, re *regexp.Regexp) bool {
 	}
 
 	var mu sync.Mutex
-	globalActiveSet := make(map[string]bool)
+	groups := make(map[string]*activeKeyGroup) // By physical device with -M, or all in "".
 	var wg sync.WaitGroup
 
 	for _, entry := range files {
@@ -138,7 +132,7 @@ func printActiveKeysFast(sel evutil.Selector, re *regexp.Regexp) bool {
 				return
 			}
 
-			if !evutil.Matches(sel, &rawDevice{path: path, name: name}) {
+			if !evutil.Matches(sel, matchableDevice(path, name)) {
 				return
 			}
 
@@ -164,9 +158,19 @@ func printActiveKeysFast(sel evutil.Selector, re *regexp.Regexp) bool {
 			}
 
 			if len(deviceKeys) > 0 {
+				group := ""
+				if *merge {
+					group = physicalDevice(path)
+				}
 				mu.Lock()
+				g, ok := groups[group]
+				if !ok {
+					g = &activeKeyGroup{keys: make(map[string]bool)}
+					groups[group] = g
+				}
+				g.members = append(g.members, groupMember{path, name})
 				for _, keyName := range deviceKeys {
-					globalActiveSet[keyName] = true
+					g.keys[keyName] = true
 				}
 				mu.Unlock()
 			}
@@ -174,6 +178,61 @@ func printActiveKeysFast(sel evutil.Selector, re *regexp.Regexp) bool {
 	}
 	wg.Wait()
 
+	if !*merge {
+		var keys, summaries []string
+		if g, ok := groups[""]; ok {
+			keys, summaries = activeKeyLines(g.keys, re)
+		}
+		printLines(keys, summaries)
+		if re != nil {
+			return len(keys) > 0 || len(summaries) > 0
+		}
+		return true
+	}
+
+	sorted := make([]*activeKeyGroup, 0, len(groups))
+	for _, g := range groups {
+		slices.SortFunc(g.members, func(a, b groupMember) int {
+			return utils.LessToCmp(natural.Less)(a.path, b.path)
+		})
+		sorted = append(sorted, g)
+	}
+	slices.SortFunc(sorted, func(a, b *activeKeyGroup) int {
+		return utils.LessToCmp(natural.Less)(a.members[0].path, b.members[0].path)
+	})
+	matched := false
+	for _, g := range sorted {
+		keys, summaries := activeKeyLines(g.keys, re)
+		if len(keys) == 0 && len(summaries) == 0 {
+			continue
+		}
+		matched = true
+		paths := make([]string, len(g.members))
+		for i, m := range g.members {
+			paths[i] = m.path
+		}
+		fmt.Printf("# %s (%s)\n", g.members[0].name, strings.Join(paths, ", "))
+		printLines(keys, summaries)
+	}
+	return re == nil || matched
+}
+
+// activeKeyGroup is the active keys of the devices of a physical device, or all the devices.
+type activeKeyGroup struct {
+	members []groupMember
+	keys    map[string]bool
+}
+
+func printLines(lists ...[]string) {
+	for _, lines := range lists {
+		for _, l := range lines {
+			fmt.Println(l)
+		}
+	}
+}
+
+// activeKeyLines returns the active keys matching re, and the summary lines, such as "#c-s-KEY_A".
+func activeKeyLines(globalActiveSet map[string]bool, re *regexp.Regexp) ([]string, []string) {
 	var shiftActive, ctrlActive, altActive, winActive bool
 	for k := range globalActiveSet {
 		switch k {
@@ -231,16 +290,5 @@ func printActiveKeysFast(sel evutil.Selector, re *regexp.Regexp) bool {
 
 	slices.Sort(matchedKeys)
 	slices.Sort(matchedSummaries)
-
-	for _, k := range matchedKeys {
-		fmt.Println(k)
-	}
-	for _, s := range matchedSummaries {
-		fmt.Println(s)
-	}
-
-	if re != nil {
-		return len(matchedKeys) > 0 || len(matchedSummaries) > 0
-	}
-	return true
+	return matchedKeys, matchedSummaries
 }
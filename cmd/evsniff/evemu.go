@@ -0,0 +1,429 @@
+package main
+
+// Support for the evemu-record file format, so input sessions can be attached to
+// bug reports and rendered later with --replay-file on machines without the hardware.
+//
+// See https://gitlab.freedesktop.org/libevdev/evemu for the format. A file starts
+// with a device description:
+//
+//	N: <name>
+//	I: <bus> <vendor> <product> <version>     (hex)
+//	P: <property bitmask bytes>               (8 bytes per line)
+//	B: <type> <code bitmask bytes>            (8 bytes per line, type 00 is the type bitmask)
+//	A: <code> <min> <max> <fuzz> <flat> <resolution>
+//
+// followed by one line per event:
+//
+//	E: <sec>.<usec> <type> <code> <value>
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+	"github.com/omakoto/go-common/src/utils"
+)
+
+// inputDevice is the subset of *evdev.InputDevice that's needed to describe a device.
+// It's also implemented by devices loaded from a recording.
+type inputDevice interface {
+	Path() string
+	Name() (string, error)
+	InputID() (evdev.InputID, error)
+	CapableTypes() []evdev.EvType
+	CapableEvents(t evdev.EvType) []evdev.EvCode
+	State(t evdev.EvType) (evdev.StateMap, error)
+	AbsInfos() (map[evdev.EvCode]evdev.AbsInfo, error)
+	Properties() []evdev.EvProp
+}
+
+var _ inputDevice = (*evdev.InputDevice)(nil)
+
+// maxCodes is the highest code of each event type, which decides the size of the "B:" bitmasks.
+var maxCodes = map[evdev.EvType]int{
+	evdev.EV_SYN: evdev.EV_MAX, // "B: 00" holds the event type bitmask.
+	evdev.EV_KEY: evdev.KEY_MAX,
+	evdev.EV_REL: evdev.REL_MAX,
+	evdev.EV_ABS: evdev.ABS_MAX,
+	evdev.EV_MSC: evdev.MSC_MAX,
+	evdev.EV_SW:  evdev.SW_MAX,
+	evdev.EV_LED: evdev.LED_MAX,
+	evdev.EV_SND: evdev.SND_MAX,
+	evdev.EV_REP: evdev.REP_MAX,
+	evdev.EV_FF:  evdev.FF_MAX,
+}
+
+// makeBitmask converts a list of bit numbers into a bitmask that's big enough for maxBit,
+// rounded up to 8 bytes like evemu-record does.
+func makeBitmask[T ~uint16](bits []T, maxBit int) []byte {
+	ret := make([]byte, (maxBit/64+1)*8)
+	for _, b := range bits {
+		if int(b) <= maxBit {
+			ret[b/8] |= 1 << (b % 8)
+		}
+	}
+	return ret
+}
+
+func bitmaskBits(bits []byte) []int {
+	ret := make([]int, 0)
+	for i := 0; i < len(bits)*8; i++ {
+		if bitIsSet(bits, i) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func writeBitmaskLines(w *bufio.Writer, prefix string, bits []byte) {
+	for i := 0; i < len(bits); i += 8 {
+		fmt.Fprint(w, prefix)
+		for _, b := range bits[i:min(i+8, len(bits))] {
+			fmt.Fprintf(w, " %02x", b)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeEvemuHeader writes the device description of d.
+func writeEvemuHeader(w *bufio.Writer, d inputDevice) error {
+	name, err := d.Name()
+	if err != nil {
+		return err
+	}
+	id, err := d.InputID()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "# EVEMU 1.3")
+	fmt.Fprintf(w, "# Input device name: \"%s\"\n", name)
+	fmt.Fprintf(w, "# Input device ID: bus %#x vendor %#x product %#x version %#x\n",
+		id.BusType, id.Vendor, id.Product, id.Version)
+	fmt.Fprintf(w, "# Path: %s\n", d.Path())
+	fmt.Fprintln(w, "# Recorded by evsniff")
+
+	fmt.Fprintf(w, "N: %s\n", name)
+	fmt.Fprintf(w, "I: %04x %04x %04x %04x\n", id.BusType, id.Vendor, id.Product, id.Version)
+	writeBitmaskLines(w, "P:", makeBitmask(d.Properties(), evdev.INPUT_PROP_MAX))
+
+	types := d.CapableTypes()
+	slices.Sort(types)
+	writeBitmaskLines(w, "B: 00", makeBitmask(types, evdev.EV_MAX))
+	for _, t := range types {
+		max, ok := maxCodes[t]
+		if !ok || t == evdev.EV_SYN {
+			continue
+		}
+		writeBitmaskLines(w, fmt.Sprintf("B: %02x", t), makeBitmask(d.CapableEvents(t), max))
+	}
+
+	if slices.Contains(types, evdev.EV_ABS) {
+		absInfos, err := d.AbsInfos()
+		if err == nil {
+			for code, a := range utils.SortedMap(absInfos) {
+				fmt.Fprintf(w, "A: %02x %d %d %d %d %d\n", code, a.Minimum, a.Maximum, a.Fuzz, a.Flat, a.Resolution)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+func writeEvemuEvent(w *bufio.Writer, e *evdev.InputEvent) {
+	fmt.Fprintf(w, "E: %d.%06d %04x %04x %04d\t# %s / %-20s %d\n",
+		e.Time.Sec, e.Time.Usec, e.Type, e.Code, e.Value, e.TypeName(), e.CodeName(), e.Value)
+}
+
+// evemuRecorder writes all events read from one device into an evemu file.
+type evemuRecorder struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+// recordMultiple is set when more than one device is recorded, in which case each
+// device gets its own file.
+var recordMultiple bool
+
+// recordPaths is the files recorded into so far.
+var recordPaths = make(map[string]bool)
+
+// recordPathFor returns the file name to record the device at devPath into.
+// The first device is recorded into --record FILE as is, unless more than one device
+// is being recorded, in which case the device node name is appended (FILE.event3).
+// A file is never reused, so a device that's reattached gets a new file (FILE.event3.2),
+// and the recording from before the disconnect is kept.
+func recordPathFor(devPath string) string {
+	path := *recordFile + "." + filepath.Base(devPath)
+	if !recordMultiple {
+		recordMultiple = true
+		path = *recordFile
+	}
+	base := path
+	for n := 2; recordPaths[path]; n++ {
+		path = fmt.Sprintf("%s.%d", base, n)
+	}
+	recordPaths[path] = true
+	return path
+}
+
+func newEvemuRecorder(d inputDevice) (*evemuRecorder, error) {
+	path := recordPathFor(d.Path())
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &evemuRecorder{file: f, w: bufio.NewWriter(f)}
+	if err := writeEvemuHeader(r.w, d); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if *verbose {
+		fmt.Printf("Recording %s to %s\n", d.Path(), path)
+	}
+	return r, nil
+}
+
+func (r *evemuRecorder) record(e *evdev.InputEvent) {
+	writeEvemuEvent(r.w, e)
+
+	// Flush at the end of each packet, so an interrupted recording is still usable.
+	if e.Type == evdev.EV_SYN {
+		if err := r.w.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing to %s: %v\n", r.file.Name(), err)
+		}
+	}
+}
+
+func (r *evemuRecorder) close() {
+	_ = r.w.Flush()
+	_ = r.file.Close()
+}
+
+// evemuDevice is a device loaded from an evemu recording.
+type evemuDevice struct {
+	path     string
+	name     string
+	id       evdev.InputID
+	props    []byte
+	bits     map[evdev.EvType][]byte
+	absInfos map[evdev.EvCode]evdev.AbsInfo
+	events   []evdev.InputEvent
+}
+
+var _ inputDevice = (*evemuDevice)(nil)
+var _ evutil.Device = (*evemuDevice)(nil)
+
+func (d *evemuDevice) Path() string {
+	return d.path
+}
+
+func (d *evemuDevice) Name() (string, error) {
+	return d.name, nil
+}
+
+func (d *evemuDevice) InputID() (evdev.InputID, error) {
+	return d.id, nil
+}
+
+func (d *evemuDevice) CapableTypes() []evdev.EvType {
+	types := make([]evdev.EvType, 0)
+	for _, t := range bitmaskBits(d.bits[0]) {
+		types = append(types, evdev.EvType(t))
+	}
+	return types
+}
+
+func (d *evemuDevice) CapableEvents(t evdev.EvType) []evdev.EvCode {
+	codes := make([]evdev.EvCode, 0)
+	for _, c := range bitmaskBits(d.bits[t]) {
+		codes = append(codes, evdev.EvCode(c))
+	}
+	return codes
+}
+
+// State returns all the supported codes as released, because recordings don't carry the device state.
+func (d *evemuDevice) State(t evdev.EvType) (evdev.StateMap, error) {
+	switch t {
+	case evdev.EV_KEY, evdev.EV_SW, evdev.EV_LED, evdev.EV_SND:
+	default:
+		return nil, fmt.Errorf("unsupported evType %d", t)
+	}
+	st := evdev.StateMap{}
+	for _, c := range d.CapableEvents(t) {
+		st[c] = false
+	}
+	return st, nil
+}
+
+func (d *evemuDevice) AbsInfos() (map[evdev.EvCode]evdev.AbsInfo, error) {
+	return d.absInfos, nil
+}
+
+func (d *evemuDevice) Properties() []evdev.EvProp {
+	props := make([]evdev.EvProp, 0)
+	for _, p := range bitmaskBits(d.props) {
+		props = append(props, evdev.EvProp(p))
+	}
+	return props
+}
+
+func parseHexBytes(fields []string) ([]byte, error) {
+	ret := make([]byte, 0, len(fields))
+	for _, f := range fields {
+		b, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, byte(b))
+	}
+	return ret, nil
+}
+
+// readEvemuFile loads a recording. The device path is taken from the "# Path:" comment
+// written by evsniff, or defaults to the file name.
+func readEvemuFile(file string) (*evemuDevice, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &evemuDevice{
+		path:     file,
+		bits:     make(map[evdev.EvType][]byte),
+		absInfos: make(map[evdev.EvCode]evdev.AbsInfo),
+	}
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# Path: ") {
+			d.path = strings.TrimSpace(line[len("# Path: "):])
+			continue
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			return nil, fmt.Errorf("%s:%d: invalid line", file, lineNo)
+		}
+		body := line[2:]
+		if i := strings.IndexByte(body, '#'); i >= 0 {
+			body = body[:i]
+		}
+		fields := strings.Fields(body)
+
+		err := error(nil)
+		switch line[0] {
+		case 'N':
+			d.name = strings.TrimSpace(line[2:])
+		case 'I':
+			var bus, vendor, product, version uint16
+			_, err = fmt.Sscanf(body, "%x %x %x %x", &bus, &vendor, &product, &version)
+			d.id = evdev.InputID{BusType: bus, Vendor: vendor, Product: product, Version: version}
+		case 'P':
+			var b []byte
+			b, err = parseHexBytes(fields)
+			d.props = append(d.props, b...)
+		case 'B':
+			var b []byte
+			b, err = parseHexBytes(fields)
+			if err == nil && len(b) > 0 {
+				t := evdev.EvType(b[0])
+				d.bits[t] = append(d.bits[t], b[1:]...)
+			}
+		case 'A':
+			var code evdev.EvCode
+			var a evdev.AbsInfo
+			_, err = fmt.Sscanf(body, "%x %d %d %d %d %d", &code, &a.Minimum, &a.Maximum, &a.Fuzz, &a.Flat, &a.Resolution)
+			d.absInfos[code] = a
+		case 'E':
+			var e evdev.InputEvent
+			e, err = parseEvemuEvent(fields)
+			d.events = append(d.events, e)
+		default:
+			// Ignore other lines (e.g. "L:" and "S:") we don't use.
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func parseEvemuEvent(fields []string) (evdev.InputEvent, error) {
+	var e evdev.InputEvent
+	if len(fields) < 4 {
+		return e, fmt.Errorf("invalid event")
+	}
+	sec, usec, ok := strings.Cut(fields[0], ".")
+	if !ok {
+		return e, fmt.Errorf("invalid timestamp %q", fields[0])
+	}
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return e, err
+	}
+	us, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return e, err
+	}
+	t, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil {
+		return e, err
+	}
+	c, err := strconv.ParseUint(fields[2], 16, 16)
+	if err != nil {
+		return e, err
+	}
+	v, err := strconv.ParseInt(fields[3], 10, 32)
+	if err != nil {
+		return e, err
+	}
+	e.Time = syscall.NsecToTimeval(s*1_000_000_000 + us*1_000)
+	e.Type = evdev.EvType(t)
+	e.Code = evdev.EvCode(c)
+	e.Value = int32(v)
+	return e, nil
+}
+
+// replayEvemuFile renders a recording through the same filters and formatting as a live device.
+func replayEvemuFile(file string, col colorizer, sel evutil.Selector) int {
+	d, err := readEvemuFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
+		return 2
+	}
+	if !evutil.Matches(sel, d) {
+		fmt.Println("No devices selected.")
+		return 1
+	}
+	dumpDevice(d, "    ")
+	if *infoOnly {
+		return 0
+	}
+	if *uinputReplay {
+		return replayToUinput(d, col)
+	}
+
+	for i := range d.events {
+		handleEvent(&d.events[i], col, d.path, d.id, d.name)
+	}
+	if *showHz {
+		printRateSummaries(col)
+	}
+	return 0
+}
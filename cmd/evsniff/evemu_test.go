@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestEvemuRoundTrip(t *testing.T) {
+	src := &evemuDevice{
+		path: "/dev/input/event3",
+		name: "Mock Touchpad # 1",
+		id:   evdev.InputID{BusType: 0x03, Vendor: 0x046D, Product: 0xC31C, Version: 0x0111},
+		props: makeBitmask([]evdev.EvProp{evdev.INPUT_PROP_POINTER, evdev.INPUT_PROP_BUTTONPAD},
+			evdev.INPUT_PROP_MAX),
+		bits: map[evdev.EvType][]byte{
+			evdev.EV_SYN: makeBitmask([]evdev.EvType{evdev.EV_SYN, evdev.EV_KEY, evdev.EV_ABS}, evdev.EV_MAX),
+			evdev.EV_KEY: makeBitmask([]evdev.EvCode{evdev.KEY_A, evdev.BTN_LEFT}, evdev.KEY_MAX),
+			evdev.EV_ABS: makeBitmask([]evdev.EvCode{evdev.ABS_X}, evdev.ABS_MAX),
+		},
+		absInfos: map[evdev.EvCode]evdev.AbsInfo{
+			evdev.ABS_X: {Minimum: -10, Maximum: 1023, Fuzz: 1, Flat: 2, Resolution: 40},
+		},
+	}
+
+	file := filepath.Join(t.TempDir(), "test.evemu")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	if err := writeEvemuHeader(w, src); err != nil {
+		t.Fatal(err)
+	}
+	events := []evdev.InputEvent{
+		{Time: syscall.NsecToTimeval(1_000_000_500_000), Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: 1},
+		{Time: syscall.NsecToTimeval(1_000_000_500_000), Type: evdev.EV_ABS, Code: evdev.ABS_X, Value: -3},
+		{Time: syscall.NsecToTimeval(1_000_000_500_000), Type: evdev.EV_SYN, Code: evdev.SYN_REPORT, Value: 0},
+	}
+	for i := range events {
+		writeEvemuEvent(w, &events[i])
+	}
+	w.Flush()
+	f.Close()
+
+	got, err := readEvemuFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.path != src.path || got.name != src.name || got.id != src.id {
+		t.Errorf("device mismatch: got %q %q %+v", got.path, got.name, got.id)
+	}
+	if !slices.Equal(got.CapableTypes(), src.CapableTypes()) {
+		t.Errorf("types: got %v, want %v", got.CapableTypes(), src.CapableTypes())
+	}
+	if !slices.Equal(got.CapableEvents(evdev.EV_KEY), src.CapableEvents(evdev.EV_KEY)) {
+		t.Errorf("keys: got %v, want %v", got.CapableEvents(evdev.EV_KEY), src.CapableEvents(evdev.EV_KEY))
+	}
+	if !slices.Equal(got.Properties(), src.Properties()) {
+		t.Errorf("properties: got %v, want %v", got.Properties(), src.Properties())
+	}
+	if got.absInfos[evdev.ABS_X] != src.absInfos[evdev.ABS_X] {
+		t.Errorf("absinfo: got %+v, want %+v", got.absInfos[evdev.ABS_X], src.absInfos[evdev.ABS_X])
+	}
+	if !slices.Equal(got.events, events) {
+		t.Errorf("events: got %+v, want %+v", got.events, events)
+	}
+}
+
+func TestRecordPathFor(t *testing.T) {
+	resetFlags()
+	*recordFile = "kbd.evemu"
+	recordMultiple = true
+	clear(recordPaths)
+	defer clear(recordPaths)
+
+	for _, tt := range []struct{ dev, want string }{
+		{"/dev/input/event3", "kbd.evemu.event3"},
+		{"/dev/input/event4", "kbd.evemu.event4"},
+		{"/dev/input/event3", "kbd.evemu.event3.2"}, // Reattached.
+		{"/dev/input/event3", "kbd.evemu.event3.3"},
+	} {
+		if got := recordPathFor(tt.dev); got != tt.want {
+			t.Errorf("recordPathFor(%s) = %s, want %s", tt.dev, got, tt.want)
+		}
+	}
+
+	// A single device that's reattached doesn't overwrite FILE.
+	recordMultiple = false
+	clear(recordPaths)
+	if got := recordPathFor("/dev/input/event3"); got != "kbd.evemu" {
+		t.Errorf("got %s", got)
+	}
+	if got := recordPathFor("/dev/input/event3"); got != "kbd.evemu.event3" {
+		t.Errorf("got %s after a reattach", got)
+	}
+}
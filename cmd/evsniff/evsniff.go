@@ -2,18 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"slices"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/omakoto/evsniff-go/evutil"
 
-	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/holoplot/go-evdev"
-	"github.com/jhenstridge/go-inotify"
 	"github.com/maruel/natural"
 	"github.com/mattn/go-isatty"
 	"github.com/omakoto/go-common/src/common"
@@ -36,11 +36,29 @@ var (
 	showScan      = getopt.BoolLong("show-scan", 'S', "show MSC_SCAN events (hidden by default)")
 	noRel         = getopt.BoolLong("no-rel", 'R', "suppress EV_REL (relative axis) events")
 	noAbs         = getopt.BoolLong("no-abs", 'A', "suppress EV_ABS (absolute axis) events")
-	showHz        = getopt.BoolLong("show-hz", 'H', "show event rate in Hz")
+	showHz        = getopt.BoolLong("show-hz", 'H', "show event rate in Hz per device and event type, with a periodic summary")
 	grab          = getopt.BoolLong("grab", 'g', "grab device for exclusive access")
 	simple        = getopt.BoolLong("simple", 's', "key-press events only, with modifier key state (for scripting)")
 	activeKeys    = getopt.BoolLong("active-keys", 'a', "find all active keys from the selected devices, print their names, and exit")
 	keyRegex      = getopt.StringLong("key-regex", 'r', "", "regular expression to match active keys when -a is passed")
+	jsonOutput    = getopt.BoolLong("json", 'j', "print one JSON object per event (JSON Lines) instead of text")
+	recordFile    = getopt.StringLong("record", 0, "", "record all events from the selected devices to FILE in the evemu format", "FILE")
+	recordMidi    = getopt.StringLong("record-midi", 0, "", "record the events from the selected MIDI devices to FILE as a Standard MIDI File", "FILE")
+	dropRealtime  = getopt.BoolLong("midi-drop-realtime", 0, "with --record-midi, don't record real-time messages (clock, active sensing, ...)")
+	playMidi      = getopt.StringLong("play-midi", 0, "", "render the events in the Standard MIDI File FILE and quit", "FILE")
+	midiRealtime  = getopt.BoolLong("realtime", 0, "with --play-midi, print the events with their original timing")
+	replayFile    = getopt.StringLong("replay-file", 0, "", "render events recorded with --record (or evemu-record) from FILE and quit", "FILE")
+	uinputReplay  = getopt.BoolLong("uinput", 0, "with --replay-file, re-emit the events through a virtual uinput device with the original timing")
+	replaySpeed   = float64Long("speed", 0, 1.0, "with --uinput or --realtime, replay speed factor (2 = twice as fast)", "FACTOR")
+	replayLoop    = getopt.IntLong("loop", 0, 1, "with --uinput, replay the recording N times (0 = forever)", "N")
+	geteventOut   = getopt.BoolLong("getevent", 0, "print events in the same format as Android's \"getevent -lt\"")
+	importFile    = getopt.StringLong("import-getevent", 0, "", "render a log of Android's getevent from FILE (- for stdin) and quit", "FILE")
+	globalMods    = getopt.BoolLong("global-mods", 0, "in simple mode, combine the modifier state of all the selected devices")
+	where         = getopt.StringLong("where", 'w', "", "only show events matching EXPR (see README for the syntax)", "EXPR")
+	merge         = getopt.BoolLong("merge", 'M', "show the events of all the nodes of a physical device under one header; with -a, report each physical device")
+	profile       = getopt.StringLong("profile", 'P', "", "use the flags and FILTERs of profile NAME in the config file (see README)", "NAME")
+	explain       = getopt.BoolLong("explain-selection", 0, "print why each device is selected or not by the FILTERs, and quit")
+	modGroupSpecs = getopt.ListLong("mod-group", 0, "in simple mode, combine the modifier state of the devices matching FILTER into group NAME (can be repeated)", "NAME=FILTER")
 )
 
 var (
@@ -65,6 +83,30 @@ func resetFlags() {
 	*simple = false
 	*activeKeys = false
 	*keyRegex = ""
+	*jsonOutput = false
+	*recordFile = ""
+	*recordMidi = ""
+	*dropRealtime = false
+	*playMidi = ""
+	*midiRealtime = false
+	*replayFile = ""
+	*uinputReplay = false
+	*replaySpeed = 1.0
+	*replayLoop = 1
+	*geteventOut = false
+	*importFile = ""
+	*globalMods = false
+	*modGroupSpecs = nil
+	*where = ""
+	*explain = false
+	*profile = ""
+	*merge = false
+}
+
+func float64Long(name string, short rune, value float64, helpvalue ...string) *float64 {
+	p := value
+	getopt.FlagLong(&p, name, short, helpvalue...)
+	return &p
 }
 
 func main() {
@@ -73,6 +115,13 @@ func main() {
 
 func parseArgs(args []string) (col colorizer, sel evutil.Selector) {
 	resetFlags()
+	cfg := loadConfigAndAliases()
+	args, err := expandProfiles(args, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		osExit(2)
+		return
+	}
 	getopt.SetParameters("[FILTER...]")
 	getopt.SetUsage(func() {
 		getopt.PrintUsage(os.Stderr)
@@ -82,7 +131,13 @@ func parseArgs(args []string) (col colorizer, sel evutil.Selector) {
 			"New devices plugged in after startup are picked up automatically.\n"+
 			"\n"+
 			"  FILTER  A regex matched against the device name (case-insensitive), or a full\n"+
-			"          /dev/input/... or /dev/snd/... path. Prepend ! to exclude matching devices.\n"+
+			"          /dev/input/... or /dev/snd/... path, a device class such as @keyboard, @mouse\n"+
+			"          or @touchpad, a udev property such as @ID_SEAT=seat1, or an attribute: vendor=HEX,\n"+
+			"          product=HEX, bus=usb|bluetooth|..., phys=GLOB or uniq=GLOB.\n"+
+			"          Prepend ! to exclude matching devices.\n"+
+			"          Prepend + to also select the other nodes of the same physical device.\n"+
+			"          Combine FILTERs with & (and), | (or), ! (not) and parentheses in one\n"+
+			"          argument, e.g. '(logitech & keyboard) | vendor=1b1c & !consumer'.\n"+
 			"          Without any FILTER, all devices are monitored.\n"+
 			"\n"+
 			"  Examples:\n"+
@@ -90,13 +145,30 @@ func parseArgs(args []string) (col colorizer, sel evutil.Selector) {
 			"    evsniff keyboard                monitor devices with \"keyboard\" in their name\n"+
 			"    evsniff logitech '!mouse'        Logitech devices, excluding mice\n"+
 			"    evsniff /dev/input/event3        monitor a specific input device by path\n"+
+			"    evsniff @touchpad                monitor all touchpads\n"+
+			"    evsniff 'vendor=046d bus=usb'    monitor Logitech USB devices (both must match)\n"+
 			"    evsniff /dev/snd/midiC1D0        monitor a specific MIDI device by path\n"+
 			"    evsniff -iv                      list devices and quit\n"+
+			"    evsniff --explain-selection '!mouse'  show why each device is selected or not\n"+
 			"    evsniff -s keyboard              simple mode: one line per key-press (for scripting)\n"+
 			"    evsniff -s donner                simple mode: one line per MIDI event (for scripting)\n"+
 			"    evsniff -g keyboard              grab keyboard for exclusive access\n"+
 			"    evsniff -a keyboard              print active keys on keyboard devices and quit\n"+
 			"    evsniff -a -r KEY_A              check if KEY_A is pressed and exit 0 if so\n"+
+			"    evsniff -M +keyboard             all nodes of keyboards, with one header per keyboard\n"+
+			"    evsniff -j keyboard | jq .       print events as JSON Lines\n"+
+			"    evsniff --record kbd.evemu keyboard  record keyboard events to kbd.evemu\n"+
+			"    evsniff --record-midi take1.mid donner  record a MIDI controller to take1.mid\n"+
+			"    evsniff --replay-file kbd.evemu  show events recorded in kbd.evemu\n"+
+			"    evsniff --replay-file kbd.evemu --uinput --speed 2  replay kbd.evemu through a virtual device\n"+
+			"    evsniff --play-midi song.mid drums  show the events of the \"drums\" track of song.mid\n"+
+			"    evsniff midi-send donner note-on 1 C4  send a note to a MIDI device (see evsniff midi-send -h)\n"+
+			"    adb shell getevent -lt | evsniff --import-getevent -  show an Android getevent log\n"+
+			"    evsniff -w 'type == EV_KEY && value != 2'  key presses and releases, without autorepeat\n"+
+			"    evsniff -w 'midi.type == NoteOn && velocity > 100'  loud MIDI notes\n"+
+			"    evsniff -s --global-mods keyboard pedal  modifiers on any device apply to keys on all of them\n"+
+			"    evsniff -s --mod-group split=ergodox  share modifiers between the halves of a split keyboard\n"+
+			"    evsniff -P gaming                run profile \"gaming\" in ~/.config/evsniff/config.toml\n"+
 			"\n"+
 			"https://github.com/omakoto/evsniff-go\n"+
 			"\n")
@@ -107,8 +179,34 @@ func parseArgs(args []string) (col colorizer, sel evutil.Selector) {
 		getopt.Usage()
 		osExit(0)
 	}
+	if *profile != "" {
+		fmt.Fprintln(os.Stderr, "Error: -P / --profile must be a separate argument")
+		osExit(2)
+		return
+	}
+
+	col = newColorizer()
+
+	// Build device selector
+	or := evutil.NewCombinedSelector()
+
+	for _, arg := range getopt.CommandLine.Args() {
+		s, err := parseFilter(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			osExit(2)
+			return
+		}
+		or.Add(s)
+	}
+	sel = or
+	warnConfigErr()
+
+	return
+}
 
-	// Build color filter
+// newColorizer returns the colorizer for --color and --no-color, or for the terminal.
+func newColorizer() colorizer {
 	useColors := false
 	if *forceColor {
 		useColors = true
@@ -118,40 +216,90 @@ func parseArgs(args []string) (col colorizer, sel evutil.Selector) {
 		useColors = isatty.IsTerminal(os.Stdout.Fd())
 	}
 	if useColors {
-		col = &basicColorizer{}
-	} else {
-		col = &noColorizer{}
+		return &basicColorizer{}
 	}
+	return &noColorizer{}
+}
 
-	// Build device selector
-	or := evutil.NewCombinedSelector()
+// parseFilter parses a single FILTER argument, which can be a selector expression.
+func parseFilter(arg string) (evutil.Selector, error) {
+	if evutil.IsSelectorExpr(arg) {
+		return evutil.ParseSelector(arg, parseSimpleFilter)
+	}
+	if fields := strings.Fields(arg); len(fields) > 1 && isAttributeList(fields) {
+		and := make([]evutil.Selector, 0, len(fields))
+		for _, f := range fields {
+			s, err := parseSimpleFilter(f)
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, s)
+		}
+		return evutil.NewAndSelector(and...), nil
+	}
+	if rest, ok := strings.CutPrefix(arg, "!"); ok {
+		s, err := parseSimpleFilter(rest)
+		if err != nil {
+			return nil, err
+		}
+		return evutil.NewNegativeSelector(s), nil
+	}
+	return parseSimpleFilter(arg)
+}
 
-	for _, arg := range getopt.CommandLine.Args() {
-		var s evutil.Selector
-		negate := false
+// isAttributeList returns whether all the fields are KEY=VALUE or @ filters, such as
+// "vendor=0c45 product=7403", which must all match.
+func isAttributeList(fields []string) bool {
+	for _, f := range fields {
+		key, _, ok := strings.Cut(f, "=")
+		if !strings.HasPrefix(f, "@") && !(ok && slices.Contains(evutil.AttributeKeys, key)) {
+			return false
+		}
+	}
+	return true
+}
 
-		if strings.HasPrefix(arg, "!") {
-			negate = true
-			arg = arg[1:]
+// parseSimpleFilter parses a FILTER without negation or operators.
+func parseSimpleFilter(arg string) (evutil.Selector, error) {
+	if rest, ok := strings.CutPrefix(arg, "+"); ok {
+		s, err := parseSimpleFilter(rest)
+		if err != nil {
+			return nil, err
 		}
+		return evutil.NewSiblingSelector(s), nil
+	}
 
-		if strings.HasPrefix(arg, "/dev/input/") || strings.HasPrefix(arg, "/dev/snd/") {
-			s = evutil.NewPathSelector(arg)
+	var s evutil.Selector
+	if udev, ok := strings.CutPrefix(arg, "@"); ok {
+		if property, pattern, ok := strings.Cut(udev, "="); ok {
+			s = evutil.NewUdevSelector(property, pattern)
+		} else if slices.Contains(deviceClasses, strings.ToLower(udev)) {
+			s = evutil.NewClassSelector(udev)
+		} else if a, ok := findAlias(udev); ok {
+			s = a.sel
+		} else if configErr != nil {
+			return nil, configErr
 		} else {
-			s = evutil.NewReSelector(arg)
+			return nil, fmt.Errorf("unknown device class or alias %q; classes are: %s", udev, strings.Join(deviceClasses, ", "))
 		}
-
-		if negate {
-			s = evutil.NewNegativeSelector(s)
+	} else if key, value, ok := strings.Cut(arg, "="); ok && slices.Contains(evutil.AttributeKeys, key) {
+		as, err := evutil.NewAttributeSelector(key, value)
+		if err != nil {
+			return nil, err
 		}
-		or.Add(s)
+		s = as
+	} else if strings.HasPrefix(arg, "/dev/input/") || strings.HasPrefix(arg, "/dev/snd/") {
+		s = evutil.NewPathSelector(arg)
+	} else {
+		s = evutil.NewReSelector(arg)
 	}
-	sel = or
-
-	return
+	return s, nil
 }
 
 func realMain() int {
+	if len(os.Args) > 1 && os.Args[1] == "midi-send" {
+		return midiSendMain(os.Args[1:])
+	}
 	col, sel := parseArgs(os.Args)
 
 	if *keyRegex != "" && !*activeKeys {
@@ -175,6 +323,56 @@ func realMain() int {
 		return 1
 	}
 
+	if *uinputReplay && *replayFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: --uinput can only be used with --replay-file")
+		return 2
+	}
+	if *replaySpeed <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --speed must be positive")
+		return 2
+	}
+
+	groups, err := parseModGroups(*modGroupSpecs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	modGroups = groups
+	clear(modScopes)
+	clear(deviceIdentities)
+	clear(deviceLabels)
+	clear(deviceGroups)
+	clear(groupMembers)
+	clear(removedDevices)
+	clear(lifecycles)
+	hotplugActive = false
+
+	whereExpr = nil
+	if *where != "" {
+		whereExpr, err = parseWhere(*where)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --where expression: %v\n", err)
+			return 2
+		}
+	}
+
+	if *explain {
+		devices, closeDevices := allDevices()
+		defer closeDevices()
+		explainSelection(devices, sel, col)
+		return 0
+	}
+
+	if *replayFile != "" {
+		return replayEvemuFile(*replayFile, col, sel)
+	}
+	if *importFile != "" {
+		return importGetevent(*importFile, col, sel)
+	}
+	if *playMidi != "" {
+		return playMidiFile(*playMidi, col, sel)
+	}
+
 	devs := listDevicesFn(sel)
 	midiDevs := listMidiDevicesFn(sel)
 	if *infoOnly {
@@ -184,45 +382,97 @@ func realMain() int {
 		fmt.Println("No devices selected.")
 		return 1
 	}
+	recordMultiple = len(devs) > 1
+	clear(recordPaths)
+
+	r, err := newReactor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create epoll instance: %v\n", err)
+		return 1
+	}
+	defer r.close()
+	midiRec = nil
+	if *recordMidi != "" {
+		midiRec, err = newMidiRecorder(*recordMidi)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	// Recordings are finished when the devices are closed, and the MIDI file is written when
+	// the loop exits, so stop it on Ctrl-C instead of dying.
+	err = r.onSignal(func(sig os.Signal) {
+		r.stop()
+	}, os.Interrupt, syscall.SIGTERM)
+	common.Checkf(err, "Cannot handle signals")
+	if *showHz {
+		r.every(hzSummaryInterval, func() {
+			printRateSummaries(col)
+		})
+	}
 
-	wg := sync.WaitGroup{}
 	for _, d := range devs {
-		wg.Add(1)
-		idev := d
-		go func() {
-			defer wg.Done()
-			testDevice(idev, col)
-		}()
+		startEvdevDevice(r, d, col)
 	}
 	for _, d := range midiDevs {
-		wg.Add(1)
-		idev := d
-		go func() {
-			defer wg.Done()
-			testMidiDevice(idev, col)
-		}()
+		startMidiDevice(r, d, col)
 	}
 
 	// Watch for new devices.
-	waitForNewDevicesFn(col, sel, func(idev *evdev.InputDevice) {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testDevice(idev, col)
-		}()
+	waitForNewDevicesFn(r, col, sel, func(idev *evdev.InputDevice) {
+		startEvdevDevice(r, idev, col)
 	}, func(idev *MidiDevice) {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testMidiDevice(idev, col)
-		}()
+		startMidiDevice(r, idev, col)
 	})
 
-	wg.Wait()
+	r.run()
+	if midiRec != nil {
+		err := midiRec.close()
+		midiRec = nil
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *recordMidi, err)
+			return 1
+		}
+	}
 	return 0
 }
 
 func listDevices(sel evutil.Selector) []*evdev.InputDevice {
+	sysDevs, err := listSysfsDevices()
+	if err != nil || len(sysDevs) == 0 {
+		return listDeviceNodes(sel) // No sysfs, e.g. in a container.
+	}
+
+	ret := make([]*evdev.InputDevice, 0)
+	for _, sd := range sysDevs {
+		if !evutil.Matches(sel, sd) {
+			continue
+		}
+
+		rememberDevice(sd)
+		labelDevice(sd)
+		dumpDevice(sd, "    ")
+		if *verbose && sd.nodeErr != nil {
+			fmt.Printf("    (No state or axis info: %v)\n", sd.nodeErr)
+		}
+		if *infoOnly {
+			sd.close()
+			continue
+		}
+
+		d, err := sd.openNode()
+		sd.node = nil // Now owned by the caller.
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open %s: %v\n", sd.path, err)
+			continue
+		}
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+// listDeviceNodes is listDevices without sysfs, which opens all the device nodes.
+func listDeviceNodes(sel evutil.Selector) []*evdev.InputDevice {
 	ret := make([]*evdev.InputDevice, 0)
 	devices, err := evdev.ListDevicePaths()
 	common.Checkf(err, "Cannot list device paths")
@@ -231,20 +481,16 @@ func listDevices(sel evutil.Selector) []*evdev.InputDevice {
 
 	for _, idev := range devices {
 		d := must.Must2(evdev.Open(idev.Path))
+		nd := newNodeDevice(d)
 
-		if !evutil.Matches(sel, d) {
+		if !evutil.Matches(sel, nd) {
 			d.Close()
 			continue
 		}
 
-		dumpDevice(d, "    ")
-		if *grab {
-			err = d.Grab()
-			if err != nil {
-				fmt.Printf("Error grabbing device %s: %s\n", d.Path(), err)
-			}
-		}
-
+		rememberDevice(nd)
+		labelDevice(nd)
+		dumpDevice(nd, "    ")
 		ret = append(ret, d)
 	}
 	return ret
@@ -256,17 +502,46 @@ func sortDevices(devices []evdev.InputPath) {
 	})
 }
 
-func dumpDevice(d *evdev.InputDevice, prefix string) {
+// dumpAttributes prints the attributes for the KEY=VALUE filters, other than the IDs.
+func dumpAttributes(bus uint16, phys, uniq, prefix string) {
+	fmt.Printf("%sBus: %s  Phys: %q  Uniq: %q\n", prefix, evutil.BusName(bus), phys, uniq)
+}
+
+func dumpDevice(d inputDevice, prefix string) {
 	id, err := d.InputID()
 	if err != nil {
 		fmt.Printf("Error obtaining device info %s: %s", d.Path(), err)
 		return
 	}
 
+	if *jsonOutput {
+		printJsonDevice(d.Path(), must.Must2(d.Name()), id.Vendor, id.Product)
+		return
+	}
+
 	fmt.Printf("%-20s [v%04X p%04X]:\t%s\n", d.Path(), id.Vendor, id.Product, must.Must2(d.Name()))
+	if ad, ok := d.(evutil.AliasDevice); ok {
+		for _, alias := range ad.Aliases() {
+			fmt.Printf("%sAlias: %s\n", prefix, alias)
+		}
+	}
 	if !*verbose {
 		return
 	}
+	var phys, uniq string
+	if pd, ok := d.(evutil.PhysDevice); ok {
+		phys, _ = pd.PhysicalLocation()
+	}
+	if ud, ok := d.(evutil.UniqDevice); ok {
+		uniq, _ = ud.UniqueID()
+	}
+	dumpAttributes(id.BusType, phys, uniq, prefix)
+	if _, ok := d.(*sysfsDevice); ok {
+		fmt.Printf("%sPhysical device: %s\n", prefix, physicalDevice(d.Path()))
+	}
+	if ud, ok := d.(evutil.UdevDevice); ok {
+		dumpUdevProperties(ud, prefix)
+	}
 
 	types := d.CapableTypes()
 	slices.Sort(types)
@@ -542,95 +817,253 @@ func (n *basicColorizer) midiOther() string {
 
 var _ colorizer = (*basicColorizer)(nil)
 
-var mu = &sync.Mutex{}
-
 var lastPath string
 var lastTime time.Time = time.Time{}
-var lastEvTime float64
 
-func testDevice(d *evdev.InputDevice, col colorizer) {
+// evdevSource reads events from an evdev device on the reactor.
+type evdevSource struct {
+	rawFd  int
+	path   string
+	name   string
+	id     evdev.InputID
+	col    colorizer
+	rec    *evemuRecorder
+	lc     *deviceLifecycle
+	events []evdev.InputEvent
+
+	absCodes []evdev.EvCode
+
+	// dropping is set after SYN_DROPPED, until the next SYN_REPORT.
+	dropping bool
+}
+
+var _ reactorSource = (*evdevSource)(nil)
+
+var evioCGrab = ioc(iocWrite, 'E', 0x90, 4)
+
+// newEvdevSource takes over d: it reopens the device node as a raw non-blocking file
+// descriptor for the reactor, and closes d.
+func newEvdevSource(d *evdev.InputDevice, col colorizer) (*evdevSource, error) {
+	defer d.Close()
+
 	id, err := d.InputID()
-	common.CheckPanic(err, "Unable to get device info")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get device info: %w", err)
+	}
 	name, err := d.Name()
-	common.CheckPanic(err, "Unable to get device name")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get device name: %w", err)
+	}
 	path := d.Path()
+	absCodes := d.CapableEvents(evdev.EV_ABS)
+
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &evdevSource{
+		rawFd:  fd,
+		path:   path,
+		name:   name,
+		id:     id,
+		col:    col,
+		events: make([]evdev.InputEvent, 64),
+
+		absCodes: absCodes,
+	}
+
+	// Start from the current state, so keys that are already held are known.
+	if state, err := queryDeviceState(fd, absCodes); err == nil {
+		deviceStates[path] = state
+	}
+	modScope(path, name)
+	joinGroup(path, name)
+
+	if *grab {
+		err = doRawIoctlValue(uintptr(fd), evioCGrab, 1)
+		if err != nil {
+			fmt.Printf("Error grabbing device %s: %s\n", path, err)
+		}
+	}
+	if err := applyKernelMasks(fd); err != nil && *verbose {
+		fmt.Printf("Filtering events of %s in userspace: %v\n", path, err)
+	}
+	if *recordFile != "" {
+		s.rec, err = newEvemuRecorder(d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting recording of %s: %v\n", path, err)
+		}
+	}
 
 	if *verbose {
 		fmt.Printf("Waiting for input (%s)...\n", name)
 	}
-	for {
-		err := handleOneEvent(d, col, path, id, name)
-		if err != nil {
-			fmt.Printf("Error reading from device: %v\n", err)
-			break
+	return s, nil
+}
+
+func (s *evdevSource) fd() int {
+	return s.rawFd
+}
+
+// onReadable reads all the available events (up to the buffer size) with a single read.
+func (s *evdevSource) onReadable() error {
+	size := int(unsafe.Sizeof(s.events[0]))
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&s.events[0])), len(s.events)*size)
+
+	n, err := syscall.Read(s.rawFd, buf)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil
+	}
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		s.lc.disconnected(err)
+		return err
+	}
+
+	s.lc.events += n / size
+	for i := range n / size {
+		e := &s.events[i]
+		if s.rec != nil {
+			s.rec.record(e)
+		}
+		if s.dropping {
+			if e.Type == evdev.EV_SYN && e.Code == evdev.SYN_REPORT {
+				s.dropping = false
+				s.resync(e.Time)
+			}
+			continue
+		}
+		if e.Type == evdev.EV_SYN && e.Code == evdev.SYN_DROPPED {
+			s.dropping = true
 		}
+		handleEvent(e, s.col, s.path, s.id, s.name)
 	}
+	return nil
 }
 
-// path -> keyCode -> value
-var keyStates map[string]map[evdev.EvCode]int32 = make(map[string]map[evdev.EvCode]int32)
+// resync re-reads the device state after SYN_DROPPED and handles the changes as events.
+func (s *evdevSource) resync(tv syscall.Timeval) {
+	cur, err := queryDeviceState(s.rawFd, s.absCodes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resynchronizing %s: %v\n", s.path, err)
+		return
+	}
+	for _, e := range stateDelta(getDeviceState(s.path), cur, tv) {
+		handleEvent(&e, s.col, s.path, s.id, s.name)
+	}
+}
 
-func getKeyState(path string, key1, key2 evdev.EvCode) int32 {
-	var keys = keyStates[path]
-	var v1 = keys[key1]
-	var v2 = keys[key2]
-	if v1+v2 > 0 {
-		return 1
+func (s *evdevSource) close() {
+	if s.rec != nil {
+		s.rec.close()
 	}
-	return 0
+	// Forget the keys of removed devices, so their modifiers don't stay pressed.
+	delete(deviceStates, s.path)
+	delete(modScopes, s.path)
+	leaveGroup(s.path)
+	_ = syscall.Close(s.rawFd)
 }
 
-func handleOneEvent(d *evdev.InputDevice, col colorizer, path string, id evdev.InputID, name string) error {
-	e, err := d.ReadOne()
+// startEvdevDevice starts reading from d on the reactor.
+func startEvdevDevice(r *reactor, d *evdev.InputDevice, col colorizer) {
+	path := d.Path()
+	lc := openingDevice(r, col, path, func() error {
+		d, err := evdev.Open(path)
+		if err != nil {
+			return err
+		}
+		startEvdevDevice(r, d, col)
+		return nil
+	})
+	s, err := newEvdevSource(d, col)
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", path, err)
+		lc.failed(err)
+		return
+	}
+	s.lc = lc
+	if err := r.add(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to watch %s: %v\n", s.path, err)
+		s.close()
+		lc.failed(err)
+		return
 	}
+	lc.activate(s.name)
+}
+
+// printDeviceHeader prints the line that shows which device the following events are from.
+// Devices with an alias in the config file are shown with the alias, and with -M, the whole
+// physical device is shown.
+func printDeviceHeader(col colorizer, path string, id evdev.InputID, name string) {
+	path, name, paths := headerDevice(path, name)
+	if label, ok := deviceLabels[path]; ok {
+		fmt.Printf("%s# %s%s%s (%s)%s\n",
+			col.deviceLine(),
+			col.deviceName(),
+			label,
+			col.deviceLine(),
+			paths,
+			col.reset(),
+		)
+		return
+	}
+	fmt.Printf("%s# From device [%sv%04X p%04X%s]: %s%s%s (%s)%s\n",
+		col.deviceLine(),
+		col.deviceId(),
+		id.Vendor,
+		id.Product,
+		col.deviceLine(),
+		col.deviceName(),
+		name,
+		col.deviceLine(),
+		paths,
+		col.reset(),
+	)
+}
+
+// handleEvent filters and prints a single event, either read from a device or loaded from a recording.
+func handleEvent(e *evdev.InputEvent, col colorizer, path string, id evdev.InputID, name string) {
+	updateDeviceState(path, e)
+
 	if !*showSynReport && e.Type == evdev.EV_SYN && e.Code == evdev.SYN_REPORT {
-		return nil
+		return
 	}
 	if !*showScan && e.Type == evdev.EV_MSC && e.Code == evdev.MSC_SCAN {
-		return nil
+		return
 	}
 	if *noRel && e.Type == evdev.EV_REL {
-		return nil
+		return
 	}
 	if *noAbs && e.Type == evdev.EV_ABS {
-		return nil
+		return
+	}
+	if !matchesWhere(e, path, id, name) {
+		return
 	}
 
 	ts := fmt.Sprintf("[%s%d.%06d%s]", col.time(), e.Time.Sec, e.Time.Usec, col.reset())
 
+	now := time.Now()
+
 	hzStr := ""
-	evTime := (float64)(e.Time.Sec*1_000_000+e.Time.Usec) / 1_000_000.0
-	if *showHz && lastEvTime != 0 && evTime != lastEvTime {
-		delta := evTime - lastEvTime
-		if delta < 1 {
-			hz := (int64)(1 / delta)
-			hzStr = fmt.Sprintf(" (%dhz)", hz)
-		}
+	if *showHz {
+		hzStr = trackRate(e, path, name)
 	}
-	lastEvTime = evTime
-
-	now := time.Now()
-	mu.Lock()
-	defer mu.Unlock()
-	if !*simple && (now.Sub(lastTime) > time.Second*3 || lastPath != path) {
-		// show device name
-		fmt.Printf("%s# From device [%sv%04X p%04X%s]: %s%s%s (%s)%s\n",
-			col.deviceLine(),
-			col.deviceId(),
-			id.Vendor,
-			id.Product,
-			col.deviceLine(),
-			col.deviceName(),
-			name,
-			col.deviceLine(),
-			path,
-			col.reset(),
-		)
+	if *jsonOutput {
+		printJsonEvdevEvent(e, path, id, name)
+		return
+	}
+	if *geteventOut {
+		printGeteventLine(e, path)
+		return
+	}
+	if !*simple && (now.Sub(lastTime) > time.Second*3 || lastPath != headerKey(path)) {
+		printDeviceHeader(col, path, id, name)
 	}
 	lastTime = now
-	lastPath = path
+	lastPath = headerKey(path)
 
 	switch e.Type {
 	case evdev.EV_SYN:
@@ -649,12 +1082,6 @@ func handleOneEvent(d *evdev.InputDevice, col colorizer, path string, id evdev.I
 		switch e.Type {
 		case evdev.EV_KEY:
 			c = col.keyEvent()
-
-			// Remember the pressed keys
-			if keyStates[path] == nil {
-				keyStates[path] = make(map[evdev.EvCode]int32)
-			}
-			keyStates[path][e.Code] = e.Value
 		case evdev.EV_REL:
 			c = col.relEvent()
 		case evdev.EV_ABS:
@@ -664,171 +1091,109 @@ func handleOneEvent(d *evdev.InputDevice, col colorizer, path string, id evdev.I
 		if !*simple {
 			fmt.Printf("%s %s%s%s%s\n", ts, c, e.String(), col.reset(), hzStr)
 		} else if e.Type == evdev.EV_KEY && e.Value > 0 {
-			fmt.Printf("# s=%d c=%d a=%d m=%d type=0x%02X:%s code=0x%02X:%s value=%d vendor=%04X product=%04X path=%s # %s\n",
-				getKeyState(path, evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT),
-				getKeyState(path, evdev.KEY_LEFTCTRL, evdev.KEY_RIGHTCTRL),
-				getKeyState(path, evdev.KEY_LEFTALT, evdev.KEY_RIGHTALT),
-				getKeyState(path, evdev.KEY_LEFTMETA, evdev.KEY_RIGHTMETA),
+			paths := modScopePaths(path, name)
+			// New fields go after path=, so scripts that parse the fields by position keep working.
+			fmt.Printf("# s=%d c=%d a=%d m=%d type=0x%02X:%s code=0x%02X:%s value=%d vendor=%04X product=%04X path=%s g=%d caps=%d num=%d modsrc=%s # %s\n",
+				scopeKeyState(paths, evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT),
+				scopeKeyState(paths, evdev.KEY_LEFTCTRL, evdev.KEY_RIGHTCTRL),
+				scopeKeyState(paths, evdev.KEY_LEFTALT, evdev.KEY_RIGHTALT),
+				scopeKeyState(paths, evdev.KEY_LEFTMETA, evdev.KEY_RIGHTMETA),
 				e.Type, e.TypeName(),
 				e.Code, e.CodeName(),
 				e.Value,
 				id.Vendor, id.Product,
 				path,
+				scopeKeyState(paths, evdev.KEY_RIGHTALT),
+				scopeLedState(paths, evdev.LED_CAPSL),
+				scopeLedState(paths, evdev.LED_NUML),
+				modSources(paths),
 				name,
 			)
 		}
 	}
-	return nil
 }
 
-func waitForNewDevices(col colorizer, sel evutil.Selector, starter func(idev *evdev.InputDevice), midiStarter func(idev *MidiDevice)) {
-	w := must.Must2(inotify.NewWatcher())
-	must.Must2(w.AddWatch(devInput, inotify.IN_CREATE|inotify.IN_DELETE))
-	_, _ = w.AddWatch("/dev/snd", inotify.IN_CREATE|inotify.IN_DELETE)
+// inotifySource watches /dev/input and /dev/snd for new devices, when uevents aren't available.
+type inotifySource struct {
+	*hotplug
+	rawFd int
+}
+
+var _ reactorSource = (*inotifySource)(nil)
 
-	// Start listening for events.
-	go func() {
-		pending := mapset.NewSet[string]()
-		// for ev := range w.Event {
-		updater := make(chan bool, 16)
+func waitForNewDevices(r *reactor, col colorizer, sel evutil.Selector, starter func(idev *evdev.InputDevice), midiStarter func(idev *MidiDevice)) {
+	h := newHotplug(r, col, sel, starter, midiStarter)
+	s, err := newUeventSource(h)
+	if err == nil {
+		err = r.addBackground(s)
+		if err == nil {
+			hotplugActive = true
+			return
+		}
+		s.close()
+	}
+	if *verbose {
+		fmt.Printf("Cannot listen to uevents, using inotify: %v\n", err)
+	}
 
-		updatePending := false
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	common.Checkf(err, "Cannot initialize inotify")
+	_, err = syscall.InotifyAddWatch(fd, devInput, syscall.IN_CREATE|syscall.IN_DELETE)
+	common.Checkf(err, "Cannot watch %s", devInput)
+	_, _ = syscall.InotifyAddWatch(fd, "/dev/snd", syscall.IN_CREATE|syscall.IN_DELETE)
 
-		updaterInvoker := func() {
-			if updatePending {
-				return
-			}
-			updatePending = true
-			timer := time.NewTimer(1000 * time.Millisecond)
-
-			<-timer.C
-
-			updatePending = false
-			updater <- true
-		}
-
-		for {
-			select {
-			case ev := <-w.Event:
-				create := false
-				evCol := ""
-				evName := ""
-
-				if (ev.Mask & inotify.IN_DELETE) != 0 {
-					evCol = col.inotifyDelete()
-					evName = "DELETE"
-				} else if (ev.Mask & inotify.IN_CREATE) != 0 {
-					evCol = col.inotifyCreate()
-					evName = "CREATE"
-					create = true
-				} else {
-					break // Shouldn't happen
-				}
-
-				var path string
-				isMidi := false
-				if strings.HasPrefix(ev.Name, "event") {
-					path = devInput + "/" + ev.Name
-				} else if strings.HasPrefix(ev.Name, "midiC") {
-					path = "/dev/snd/" + ev.Name
-					isMidi = true
-				} else {
-					continue
-				}
-
-				fmt.Printf("[%sinotify%s] %s%s%s: %s%s%s\n",
-					col.inotify(),
-					col.reset(),
-					evCol,
-					evName,
-					col.reset(),
-					col.inotifyPath(),
-					path,
-					col.reset(),
-				)
-
-				_ = isMidi
-				if create {
-					pending.Add(path)
-
-					go updaterInvoker()
-				} else {
-					pending.Remove(path)
-				}
-			case <-updater:
-				if pending.IsEmpty() {
-					break
-				}
-				if *verbose {
-					fmt.Printf("[updater] %v\n", pending)
-				}
-				retries := mapset.NewSet[string]()
-				for path := range pending.Iter() {
-					if *verbose {
-						fmt.Printf("%s\n", path)
-					}
-
-					if strings.HasPrefix(path, "/dev/snd/") {
-						card, device, err := parseMidiPath(path)
-						if err != nil {
-							continue
-						}
-						f, err := os.Open(path)
-						if err != nil {
-							if os.IsPermission(err) {
-								fmt.Fprintf(os.Stderr, "%s not ready to open yet...\n", path)
-								retries.Add(path)
-								continue
-							}
-							fmt.Fprintf(os.Stderr, "Failed to open %s: '%s'\n", path, err.Error())
-							continue
-						}
-						cardNames := getCardNames()
-						name := cardNames[card]
-						if name == "" {
-							name = fmt.Sprintf("MIDI Card %d Device %d", card, device)
-						}
-						vendor, product := getMidiUsbIds(card, device)
-						idev := &MidiDevice{
-							path:    path,
-							name:    name,
-							card:    card,
-							device:  device,
-							vendor:  vendor,
-							product: product,
-							file:    f,
-						}
-						if !evutil.Matches(sel, idev) {
-							idev.file.Close()
-							continue
-						}
-						dumpMidiDevice(idev, "    ")
-						midiStarter(idev)
-					} else {
-						idev, err := evdev.Open(path)
-
-						if err != nil {
-							if os.IsPermission(err) {
-								fmt.Fprintf(os.Stderr, "%s not ready to open yet...\n", path)
-								retries.Add(path)
-								continue
-							}
-							fmt.Fprintf(os.Stderr, "Failed to open %s: '%s'\n", path, err.Error())
-							continue
-						}
-						if !evutil.Matches(sel, idev) {
-							idev.Close()
-							continue
-						}
-						dumpDevice(idev, "    ")
-						starter(idev)
-					}
-				}
-				pending = retries
-				if !pending.IsEmpty() {
-					go updaterInvoker()
-				}
-			}
+	hotplugTag = "inotify"
+	common.Checkf(r.addBackground(&inotifySource{hotplug: h, rawFd: fd}), "Cannot watch for new devices")
+	hotplugActive = true
+}
+
+func (s *inotifySource) fd() int {
+	return s.rawFd
+}
+
+func (s *inotifySource) close() {
+	_ = syscall.Close(s.rawFd)
+}
+
+func (s *inotifySource) onReadable() error {
+	var buf [4096]byte
+	n, err := syscall.Read(s.rawFd, buf[:])
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading inotify events: %v\n", err)
+		return err
+	}
+
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(ev.Len)]
+		offset += syscall.SizeofInotifyEvent + int(ev.Len)
+
+		name := string(nameBytes)
+		if i := strings.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
 		}
-	}()
+		s.onInotifyEvent(ev.Mask, name)
+	}
+	return nil
+}
+
+func (s *inotifySource) onInotifyEvent(mask uint32, name string) {
+	var path string
+	if strings.HasPrefix(name, "event") {
+		path = devInput + "/" + name
+	} else if strings.HasPrefix(name, "midiC") {
+		path = "/dev/snd/" + name
+	} else {
+		return
+	}
+
+	if (mask & syscall.IN_DELETE) != 0 {
+		s.removed(path)
+	} else if (mask & syscall.IN_CREATE) != 0 {
+		// Give the driver and udev time to finish creating the device node and setting its permissions.
+		s.added(path, false)
+	}
 }
@@ -0,0 +1,97 @@
+package main
+
+// --explain-selection: for every device, shows the result of each FILTER and the final verdict,
+// to debug FILTERs that don't select what's expected.
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+	"github.com/omakoto/go-common/src/must"
+)
+
+// allDevices returns all the evdev and MIDI devices, without opening them if possible.
+// close closes the devices that had to be opened.
+func allDevices() (devices []evutil.Device, close func()) {
+	var nodes []*evdev.InputDevice
+	close = func() {
+		for _, d := range nodes {
+			d.Close()
+		}
+	}
+
+	if sysDevs, err := listSysfsDevices(); err == nil && len(sysDevs) > 0 {
+		for _, sd := range sysDevs {
+			devices = append(devices, sd)
+		}
+	} else if paths, err := evdev.ListDevicePaths(); err == nil {
+		sortDevices(paths)
+		for _, p := range paths {
+			d, err := evdev.Open(p.Path)
+			if err != nil {
+				continue
+			}
+			nodes = append(nodes, d)
+			devices = append(devices, newNodeDevice(d))
+		}
+	}
+
+	files, _ := filepath.Glob("/dev/snd/midiC*D*")
+	cardNames := getCardNames()
+	for _, path := range files {
+		card, device, err := parseMidiPath(path)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, newMidiDevice(path, card, device, cardNames))
+	}
+	return devices, close
+}
+
+func selectionEffectColor(col colorizer, effect string) string {
+	switch effect {
+	case evutil.EffectMatched:
+		return col.inotifyCreate()
+	case evutil.EffectExcluded:
+		return col.failure()
+	}
+	return ""
+}
+
+// printSelectorResults prints the results of the selectors, and of their sub-selectors, indented.
+func printSelectorResults(results []evutil.SelectorResult, indent string, col colorizer) {
+	for _, r := range results {
+		effect := selectionEffectColor(col, r.Effect) + r.Effect + col.reset()
+		if r.Reason != "" {
+			fmt.Printf("%-34s %s: %s\n", indent+r.Label, effect, r.Reason)
+		} else {
+			fmt.Printf("%-34s %s\n", indent+r.Label, effect)
+		}
+		printSelectorResults(r.Children, indent+"  ", col)
+	}
+}
+
+// explainSelection prints why each device is selected or not by sel.
+func explainSelection(devices []evutil.Device, sel evutil.Selector, col colorizer) {
+	cs, ok := sel.(*evutil.CombinedSelector)
+	if !ok {
+		cs = evutil.NewCombinedSelector().Add(sel)
+	}
+	for _, d := range devices {
+		var id evdev.InputID
+		if idd, ok := d.(evutil.IDDevice); ok {
+			id, _ = idd.InputID()
+		}
+		fmt.Printf("%-20s [v%04X p%04X]:\t%s\n", d.Path(), id.Vendor, id.Product, must.Must2(d.Name()))
+
+		r := evutil.ExplainSelector(cs, d)
+		printSelectorResults(r.Children, "    ", col)
+		if evutil.Matches(cs, d) {
+			fmt.Printf("    => %sselected%s: %s\n", col.inotifyCreate(), col.reset(), r.Reason)
+		} else {
+			fmt.Printf("    => %srejected%s: %s\n", col.failure(), col.reset(), r.Reason)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+func TestExplainSelection(t *testing.T) {
+	keyboard := &sysfsDevice{path: "/dev/input/event3", name: "Logitech USB Keyboard"}
+	keyboard.id.Vendor = 0x046d
+	mouse := &sysfsDevice{path: "/dev/input/event4", name: "Logitech USB Receiver Mouse"}
+	midi := &MidiDevice{path: "/dev/snd/midiC1D0", name: "DONNER DMK25Pro", fd: -1}
+
+	_, sel := parseArgs([]string{"evsniff", "--explain-selection", "logitech", "!mouse"})
+	stdout, _, panicVal := captureOutput(func() {
+		explainSelection([]evutil.Device{keyboard, mouse, midi}, sel, &noColorizer{})
+	})
+	if panicVal != nil {
+		t.Fatal(panicVal)
+	}
+	want := `/dev/input/event3    [v046D p0000]:	Logitech USB Keyboard
+    logitech                       matched
+    !mouse                         abstained: mouse didn't match, so it doesn't exclude the device
+    => selected: matched logitech
+/dev/input/event4    [v0000 p0000]:	Logitech USB Receiver Mouse
+    logitech                       matched
+    !mouse                         excluded: mouse matched
+    => rejected: excluded by !mouse
+/dev/snd/midiC1D0    [v0000 p0000]:	DONNER DMK25Pro
+    logitech                       abstained: neither the name nor the port names match
+    !mouse                         abstained: mouse didn't match, so it doesn't exclude the device
+    => rejected: no FILTER matched
+`
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	_, sel = parseArgs([]string{"evsniff", "!mouse"})
+	stdout, _, _ = captureOutput(func() {
+		explainSelection([]evutil.Device{keyboard}, sel, &noColorizer{})
+	})
+	if !strings.Contains(stdout, "=> selected: there are only exclusions, and none of them matched") {
+		t.Errorf("got:\n%s", stdout)
+	}
+
+	_, sel = parseArgs([]string{"evsniff", "logitech & !mouse"})
+	stdout, _, _ = captureOutput(func() {
+		explainSelection([]evutil.Device{mouse}, sel, &noColorizer{})
+	})
+	want = `/dev/input/event4    [v0000 p0000]:	Logitech USB Receiver Mouse
+    (logitech & !mouse)            not matched: !mouse didn't match
+      logitech                     matched
+      !mouse                       not matched: mouse matched
+        mouse                      matched
+    => rejected: no FILTER matched
+`
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
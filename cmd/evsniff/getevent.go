@@ -0,0 +1,262 @@
+package main
+
+// Compatibility with Android's getevent tool: --getevent prints events the way
+// "getevent -lt" does, and --import-getevent renders a getevent log (e.g. from
+// "adb shell getevent -lt") like a live device.
+//
+// A getevent log looks like this:
+//
+//	add device 1: /dev/input/event3
+//	  name:     "gpio-keys"
+//	[   44315.306186] /dev/input/event3: EV_KEY       KEY_VOLUMEDOWN       DOWN
+//	[   44315.306186] /dev/input/event3: EV_SYN       SYN_REPORT           00000000
+//
+// Timestamps, the device path and labels (-l) are all optional; without labels, the type,
+// code and value are printed in hex.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+// geteventDefaultPath is used for events in logs that don't carry device paths,
+// i.e. logs of "getevent" watching a single device.
+const geteventDefaultPath = "getevent"
+
+var codeToString = map[evdev.EvType]map[evdev.EvCode]string{
+	evdev.EV_SYN: evdev.SYNToString,
+	evdev.EV_KEY: evdev.KEYToString,
+	evdev.EV_REL: evdev.RELToString,
+	evdev.EV_ABS: evdev.ABSToString,
+	evdev.EV_MSC: evdev.MSCToString,
+	evdev.EV_SW:  evdev.SWToString,
+	evdev.EV_LED: evdev.LEDToString,
+	evdev.EV_SND: evdev.SNDToString,
+	evdev.EV_REP: evdev.REPToString,
+	evdev.EV_FF:  evdev.FFToString,
+}
+
+var codeFromString = map[evdev.EvType]map[string]evdev.EvCode{
+	evdev.EV_SYN: evdev.SYNFromString,
+	evdev.EV_KEY: evdev.KEYFromString,
+	evdev.EV_REL: evdev.RELFromString,
+	evdev.EV_ABS: evdev.ABSFromString,
+	evdev.EV_MSC: evdev.MSCFromString,
+	evdev.EV_SW:  evdev.SWFromString,
+	evdev.EV_LED: evdev.LEDFromString,
+	evdev.EV_SND: evdev.SNDFromString,
+	evdev.EV_REP: evdev.REPFromString,
+	evdev.EV_FF:  evdev.FFFromString,
+}
+
+var keyValueLabels = []string{"UP", "DOWN", "REPEAT"}
+
+// printGeteventLine prints an event in the "getevent -lt" format.
+func printGeteventLine(e *evdev.InputEvent, path string) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[%8d.%06d] %s: ", e.Time.Sec, e.Time.Usec, path)
+
+	if label, ok := evdev.EVToString[e.Type]; ok {
+		fmt.Fprintf(&sb, "%-12.12s", label)
+	} else {
+		fmt.Fprintf(&sb, "%04x        ", e.Type)
+	}
+	if label, ok := codeToString[e.Type][e.Code]; ok {
+		fmt.Fprintf(&sb, " %-20.20s", label)
+	} else {
+		fmt.Fprintf(&sb, " %04x                ", e.Code)
+	}
+	if e.Type == evdev.EV_KEY && e.Value >= 0 && int(e.Value) < len(keyValueLabels) {
+		fmt.Fprintf(&sb, " %-20.20s", keyValueLabels[e.Value])
+	} else {
+		fmt.Fprintf(&sb, " %08x            ", uint32(e.Value))
+	}
+	fmt.Println(strings.TrimRight(sb.String(), " "))
+}
+
+// geteventDevice is a device found in a getevent log.
+type geteventDevice struct {
+	path string
+	name string
+	id   evdev.InputID
+}
+
+var _ evutil.Device = (*geteventDevice)(nil)
+
+func (d *geteventDevice) Path() string {
+	return d.path
+}
+
+func (d *geteventDevice) Name() (string, error) {
+	return d.name, nil
+}
+
+func parseGeteventType(s string) (evdev.EvType, error) {
+	if t, ok := evdev.EVFromString[s]; ok {
+		return t, nil
+	}
+	v, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid event type %q", s)
+	}
+	return evdev.EvType(v), nil
+}
+
+func parseGeteventCode(t evdev.EvType, s string) (evdev.EvCode, error) {
+	if c, ok := codeFromString[t][s]; ok {
+		return c, nil
+	}
+	v, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid event code %q", s)
+	}
+	return evdev.EvCode(v), nil
+}
+
+func parseGeteventValue(s string) (int32, error) {
+	for i, label := range keyValueLabels {
+		if s == label {
+			return int32(i), nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid event value %q", s)
+	}
+	return int32(uint32(v)), nil
+}
+
+// parseGeteventEvent parses an event line. ok is false for lines that aren't events.
+func parseGeteventEvent(line string) (e evdev.InputEvent, path string, ok bool, err error) {
+	path = geteventDefaultPath
+
+	if strings.HasPrefix(line, "[") {
+		end := strings.IndexByte(line, ']')
+		if end < 0 {
+			return e, path, false, nil
+		}
+		sec, usec, found := strings.Cut(strings.TrimSpace(line[1:end]), ".")
+		if !found {
+			return e, path, false, nil
+		}
+		s, err1 := strconv.ParseInt(sec, 10, 64)
+		us, err2 := strconv.ParseInt(usec, 10, 64)
+		if err1 != nil || err2 != nil {
+			return e, path, false, nil
+		}
+		e.Time = syscall.NsecToTimeval(s*1_000_000_000 + us*1_000)
+		line = line[end+1:]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 4 && strings.HasSuffix(fields[0], ":") {
+		path = strings.TrimSuffix(fields[0], ":")
+		fields = fields[1:]
+	}
+	if len(fields) != 3 {
+		return e, path, false, nil
+	}
+
+	if e.Type, err = parseGeteventType(fields[0]); err != nil {
+		return e, path, false, err
+	}
+	if e.Code, err = parseGeteventCode(e.Type, fields[1]); err != nil {
+		return e, path, false, err
+	}
+	if e.Value, err = parseGeteventValue(fields[2]); err != nil {
+		return e, path, false, err
+	}
+	return e, path, true, nil
+}
+
+// importGetevent reads a getevent log from file ("-" for stdin) and prints it like a live device.
+func importGetevent(file string, col colorizer, sel evutil.Selector) int {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", file, err)
+			return 2
+		}
+		defer f.Close()
+		r = f
+	}
+
+	devices := make(map[string]*geteventDevice)
+	getDevice := func(path string) *geteventDevice {
+		d, ok := devices[path]
+		if !ok {
+			d = &geteventDevice{path: path, name: path}
+			devices[path] = d
+		}
+		return d
+	}
+
+	var current *geteventDevice // The device of the last "add device" line.
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "add device ") {
+			if i := strings.Index(trimmed, ": "); i >= 0 {
+				current = getDevice(strings.TrimSpace(trimmed[i+2:]))
+			}
+			continue
+		}
+		if current != nil && strings.HasPrefix(line, " ") {
+			key, value, _ := strings.Cut(trimmed, " ")
+			value = strings.TrimSpace(value)
+			switch strings.TrimSuffix(key, ":") {
+			case "name":
+				current.name = strings.Trim(value, "\"")
+			case "vendor":
+				v, _ := strconv.ParseUint(value, 16, 16)
+				current.id.Vendor = uint16(v)
+			case "product":
+				v, _ := strconv.ParseUint(value, 16, 16)
+				current.id.Product = uint16(v)
+			case "bus":
+				v, _ := strconv.ParseUint(value, 16, 16)
+				current.id.BusType = uint16(v)
+			case "version":
+				v, _ := strconv.ParseUint(value, 16, 16)
+				current.id.Version = uint16(v)
+			}
+			continue
+		}
+		current = nil
+
+		e, path, ok, err := parseGeteventEvent(trimmed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", file, lineNo, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		d := getDevice(path)
+		if !evutil.Matches(sel, d) {
+			continue
+		}
+		handleEvent(&e, col, d.path, d.id, d.name)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
+		return 1
+	}
+	if *showHz {
+		printRateSummaries(col)
+	}
+	return 0
+}
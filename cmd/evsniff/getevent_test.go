@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestParseGeteventEvent(t *testing.T) {
+	tests := []struct {
+		line     string
+		ok       bool
+		path     string
+		sec      int64
+		usec     int64
+		evType   evdev.EvType
+		evCode   evdev.EvCode
+		evValue  int32
+		hasError bool
+	}{
+		{
+			line: "[   44315.306186] /dev/input/event2: EV_KEY       KEY_VOLUMEDOWN       DOWN",
+			ok:   true, path: "/dev/input/event2", sec: 44315, usec: 306186,
+			evType: evdev.EV_KEY, evCode: evdev.KEY_VOLUMEDOWN, evValue: 1,
+		},
+		{
+			line: "[   44315.306186] /dev/input/event2: EV_SYN       SYN_REPORT           00000000",
+			ok:   true, path: "/dev/input/event2", sec: 44315, usec: 306186,
+			evType: evdev.EV_SYN, evCode: evdev.SYN_REPORT, evValue: 0,
+		},
+		{
+			line: "/dev/input/event5: 0003 0035 ffffffff",
+			ok:   true, path: "/dev/input/event5",
+			evType: evdev.EV_ABS, evCode: evdev.ABS_MT_POSITION_X, evValue: -1,
+		},
+		{
+			line: "EV_KEY BTN_LEFT REPEAT",
+			ok:   true, path: geteventDefaultPath,
+			evType: evdev.EV_KEY, evCode: evdev.BTN_LEFT, evValue: 2,
+		},
+		{
+			line: "add device 1: /dev/input/event3",
+		},
+		{
+			line:     "/dev/input/event2: EV_KEY KEY_NOSUCHKEY DOWN",
+			hasError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		e, path, ok, err := parseGeteventEvent(tc.line)
+		if (err != nil) != tc.hasError {
+			t.Errorf("%q: unexpected error %v", tc.line, err)
+			continue
+		}
+		if ok != tc.ok {
+			t.Errorf("%q: got ok=%t, want %t", tc.line, ok, tc.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if path != tc.path || int64(e.Time.Sec) != tc.sec || int64(e.Time.Usec) != tc.usec ||
+			e.Type != tc.evType || e.Code != tc.evCode || e.Value != tc.evValue {
+			t.Errorf("%q: got path=%s time=%d.%06d event=%s", tc.line, path, e.Time.Sec, e.Time.Usec, e.String())
+		}
+	}
+}
+
+func TestGeteventRoundTrip(t *testing.T) {
+	e := evdev.InputEvent{Type: evdev.EV_REL, Code: evdev.REL_X, Value: -5}
+	e.Time.Sec = 12
+	e.Time.Usec = 3400
+
+	stdout, _, _ := captureOutput(func() {
+		printGeteventLine(&e, "/dev/input/event7")
+	})
+	want := "[      12.003400] /dev/input/event7: EV_REL       REL_X                fffffffb\n"
+	if stdout != want {
+		t.Fatalf("got %q, want %q", stdout, want)
+	}
+
+	got, path, ok, err := parseGeteventEvent(stdout)
+	if err != nil || !ok || path != "/dev/input/event7" || got != e {
+		t.Errorf("round trip failed: %+v %s %t %v", got, path, ok, err)
+	}
+}
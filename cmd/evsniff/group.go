@@ -0,0 +1,154 @@
+package main
+
+// Grouping of the event nodes of one physical device. Keyboards often have several nodes
+// (keys, consumer control, system control, mouse), which sysfs shows under the same USB device,
+// or the same HID device for Bluetooth and other buses.
+//
+// With -M, the events of all the nodes of a physical device are shown under one header, and
+// -a reports the active keys of each physical device.
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/maruel/natural"
+	"github.com/omakoto/evsniff-go/evutil"
+	"github.com/omakoto/go-common/src/utils"
+)
+
+// hidDeviceName matches the name of a HID device in sysfs, BUS:VENDOR:PRODUCT.INSTANCE, e.g.
+// 0003:046D:C31C.0001.
+var hidDeviceName = regexp.MustCompile(`^([0-9A-F]{4}):[0-9A-F]{4}:[0-9A-F]{4}\.[0-9A-F]+$`)
+
+// physicalDevice returns the sysfs path of the physical device of the input device at path:
+// the USB device, or the parent of the input device, e.g. the HID device. Devices without a
+// physical device, such as virtual devices, and devices not in sysfs are their own physical device.
+//
+// Only USB devices are looked up above the HID device; a Bluetooth device also has a USB device
+// above it, which is the Bluetooth adapter and is shared by all the Bluetooth devices.
+func physicalDevice(path string) string {
+	input, err := filepath.EvalSymlinks(filepath.Join(sysfsInputPath, filepath.Base(path), "device"))
+	if err != nil {
+		return path
+	}
+	parent := filepath.Dir(input) // .../input/inputN
+	if filepath.Base(parent) == "input" {
+		parent = filepath.Dir(parent)
+	}
+	if strings.Contains(parent+"/", "/devices/virtual/") {
+		return path
+	}
+	for p := parent; p != "/" && p != "."; p = filepath.Dir(p) {
+		if _, err := os.Stat(filepath.Join(p, "idVendor")); err == nil {
+			return p
+		}
+		if m := hidDeviceName.FindStringSubmatch(filepath.Base(p)); m != nil && m[1] != "0003" {
+			break // Not a USB HID device.
+		}
+		if filepath.Base(p) == "bluetooth" {
+			break
+		}
+	}
+	return parent
+}
+
+// siblingsOf returns the other input devices of the physical device of the device at path.
+func siblingsOf(path string) []evutil.Device {
+	ret := make([]evutil.Device, 0)
+	phys := physicalDevice(path)
+	if phys == path {
+		return ret
+	}
+	devs, err := listSysfsDevices()
+	if err != nil {
+		return ret
+	}
+	for _, d := range devs {
+		if d.path != path && physicalDevice(d.path) == phys {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+var (
+	_ evutil.SiblingDevice = (*sysfsDevice)(nil)
+	_ evutil.SiblingDevice = (*rawDevice)(nil)
+)
+
+func (d *sysfsDevice) Siblings() []evutil.Device {
+	return siblingsOf(d.path)
+}
+
+func (r *rawDevice) Siblings() []evutil.Device {
+	return siblingsOf(r.path)
+}
+
+type groupMember struct {
+	path string
+	name string
+}
+
+var (
+	deviceGroups = make(map[string]string)        // Path -> physical device of the monitored devices.
+	groupMembers = make(map[string][]groupMember) // Physical device -> monitored devices, sorted by path.
+)
+
+// joinGroup adds a monitored device to the group of its physical device.
+func joinGroup(path, name string) {
+	phys := physicalDevice(path)
+	deviceGroups[path] = phys
+	members := append(groupMembers[phys], groupMember{path, name})
+	slices.SortFunc(members, func(a, b groupMember) int {
+		return utils.LessToCmp(natural.Less)(a.path, b.path)
+	})
+	groupMembers[phys] = members
+}
+
+// leaveGroup removes a device that's no longer monitored from its group.
+func leaveGroup(path string) {
+	phys, ok := deviceGroups[path]
+	if !ok {
+		return
+	}
+	delete(deviceGroups, path)
+	members := slices.DeleteFunc(groupMembers[phys], func(m groupMember) bool {
+		return m.path == path
+	})
+	if len(members) == 0 {
+		delete(groupMembers, phys)
+	} else {
+		groupMembers[phys] = members
+	}
+}
+
+// headerKey returns the key that decides whether events need a new header: the path, or with -M,
+// the physical device.
+func headerKey(path string) string {
+	if *merge {
+		if phys, ok := deviceGroups[path]; ok {
+			return phys
+		}
+	}
+	return path
+}
+
+// headerDevice returns the device to show in the header for the device at path, and the
+// paths to show. With -M, it's the first device of the group, and all the paths of the group.
+func headerDevice(path, name string) (string, string, string) {
+	if !*merge {
+		return path, name, path
+	}
+	members := groupMembers[deviceGroups[path]]
+	if len(members) < 2 {
+		return path, name, path
+	}
+	paths := make([]string, len(members))
+	for i, m := range members {
+		paths[i] = m.path
+	}
+	return members[0].path, members[0].name, strings.Join(paths, ", ")
+}
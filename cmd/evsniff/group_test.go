@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+// setupGroupSysfs creates a sysfs tree with a USB keyboard with three nodes on two interfaces,
+// a Bluetooth mouse, and a virtual device.
+func setupGroupSysfs(t *testing.T) (usbDev, hidDev string) {
+	orig := sysfsInputPath
+	t.Cleanup(func() { sysfsInputPath = orig })
+	root := t.TempDir()
+	sysfsInputPath = filepath.Join(root, "class", "input")
+
+	usbDev = filepath.Join(root, "devices", "pci0000:00", "0000:00:14.0", "usb1", "1-2")
+	hidDev = filepath.Join(root, "devices", "pci0000:00", "0000:00:14.0", "usb1", "1-5", "1-5:1.0",
+		"bluetooth", "hci0", "hci0:256", "0005:046D:B342.0007")
+	writeSysfsFiles(t, usbDev, map[string]string{"idVendor": "046d", "idProduct": "c31c"})
+	// The Bluetooth adapter, which isn't the physical device of the Bluetooth mouse.
+	writeSysfsFiles(t, filepath.Join(root, "devices", "pci0000:00", "0000:00:14.0", "usb1", "1-5"),
+		map[string]string{"idVendor": "8087", "idProduct": "0026"})
+	nodes := map[string]string{
+		"event3":  filepath.Join(usbDev, "1-2:1.0", "0003:046D:C31C.0001", "input", "input3"),
+		"event4":  filepath.Join(usbDev, "1-2:1.1", "0003:046D:C31C.0002", "input", "input4"),
+		"event5":  filepath.Join(usbDev, "1-2:1.1", "0003:046D:C31C.0002", "input", "input5"),
+		"event10": filepath.Join(hidDev, "input", "input10"),
+		"event20": filepath.Join(root, "devices", "virtual", "input", "input20"),
+		"event21": filepath.Join(root, "devices", "virtual", "input", "input21"),
+	}
+	names := map[string]string{
+		"event3":  "Logitech USB Keyboard",
+		"event4":  "Logitech USB Keyboard Consumer Control",
+		"event5":  "Logitech USB Keyboard System Control",
+		"event10": "MX Anywhere 3 Mouse",
+		"event20": "Virtual Keyboard",
+		"event21": "Virtual Mouse",
+	}
+	for event, dir := range nodes {
+		writeSysfsFiles(t, dir, map[string]string{"name": names[event]})
+		if err := os.MkdirAll(filepath.Join(sysfsInputPath, event), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(dir, filepath.Join(sysfsInputPath, event, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return usbDev, hidDev
+}
+
+func TestPhysicalDevice(t *testing.T) {
+	usbDev, hidDev := setupGroupSysfs(t)
+	for path, want := range map[string]string{
+		"/dev/input/event3":  usbDev,
+		"/dev/input/event5":  usbDev,
+		"/dev/input/event10": hidDev,
+		"/dev/input/event20": "/dev/input/event20",
+		"/dev/input/event99": "/dev/input/event99",
+	} {
+		if resolved, err := filepath.EvalSymlinks(want); err == nil {
+			want = resolved // In case the temporary directory is a symlink.
+		}
+		if got := physicalDevice(path); got != want {
+			t.Errorf("%s: got %s, want %s", path, got, want)
+		}
+	}
+
+	var siblings []string
+	for _, d := range siblingsOf("/dev/input/event4") {
+		siblings = append(siblings, d.Path())
+	}
+	if want := []string{"/dev/input/event3", "/dev/input/event5"}; !slices.Equal(siblings, want) {
+		t.Errorf("siblings = %v, want %v", siblings, want)
+	}
+	if got := siblingsOf("/dev/input/event20"); len(got) != 0 {
+		t.Errorf("virtual devices have siblings: %v", got)
+	}
+}
+
+func TestSiblingSelector(t *testing.T) {
+	setupGroupSysfs(t)
+	devs, err := listSysfsDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filter, want := range map[string][]string{
+		"+consumer":            {"/dev/input/event3", "/dev/input/event4", "/dev/input/event5"},
+		"consumer":             {"/dev/input/event4"},
+		"+virtual keyboard":    {"/dev/input/event20"},
+		"+mouse":               {"/dev/input/event10", "/dev/input/event21"},
+		"+consumer & !control": {"/dev/input/event3"},
+	} {
+		s, err := parseFilter(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sel := evutil.NewCombinedSelector().Add(s)
+		var got []string
+		for _, d := range devs {
+			if evutil.Matches(sel, d) {
+				got = append(got, d.path)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", filter, got, want)
+		}
+	}
+}
+
+func TestMergedHeader(t *testing.T) {
+	setupGroupSysfs(t)
+	resetFlags()
+	defer resetFlags()
+	defer clear(deviceGroups)
+	defer clear(groupMembers)
+
+	joinGroup("/dev/input/event4", "Logitech USB Keyboard Consumer Control")
+	joinGroup("/dev/input/event3", "Logitech USB Keyboard")
+	joinGroup("/dev/input/event20", "Virtual Keyboard")
+
+	print := func() string {
+		stdout, _, _ := captureOutput(func() {
+			printDeviceHeader(&noColorizer{}, "/dev/input/event4", evdev.InputID{Vendor: 0x046d, Product: 0xc31c}, "Logitech USB Keyboard Consumer Control")
+		})
+		return stdout
+	}
+	if got, want := print(), "# From device [v046D pC31C]: Logitech USB Keyboard Consumer Control (/dev/input/event4)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if headerKey("/dev/input/event3") == headerKey("/dev/input/event4") {
+		t.Errorf("nodes are merged without -M")
+	}
+
+	*merge = true
+	if got, want := print(), "# From device [v046D pC31C]: Logitech USB Keyboard (/dev/input/event3, /dev/input/event4)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if headerKey("/dev/input/event3") != headerKey("/dev/input/event4") || headerKey("/dev/input/event3") == headerKey("/dev/input/event20") {
+		t.Errorf("wrong header keys")
+	}
+
+	leaveGroup("/dev/input/event3")
+	if got, want := print(), "# From device [v046D pC31C]: Logitech USB Keyboard Consumer Control (/dev/input/event4)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestActiveKeysPerPhysicalDevice(t *testing.T) {
+	setupGroupSysfs(t)
+	cleanup := setupMockDevices([]mockDeviceSpec{
+		{path: "/dev/input/event3", name: "Logitech USB Keyboard", supportedKeys: []int{30, 42}, activeKeys: []int{30}},
+		{path: "/dev/input/event4", name: "Logitech USB Keyboard Consumer Control", supportedKeys: []int{42}, activeKeys: []int{42}},
+		{path: "/dev/input/event20", name: "Virtual Keyboard", supportedKeys: []int{48}, activeKeys: []int{48}},
+		{path: "/dev/input/event21", name: "Virtual Mouse", supportedKeys: []int{48}},
+	})
+	defer cleanup()
+	resetFlags()
+	defer resetFlags()
+	*merge = true
+
+	var ok bool
+	stdout, _, _ := captureOutput(func() {
+		ok = printActiveKeysFast(evutil.NewCombinedSelector(), nil)
+	})
+	want := "# Logitech USB Keyboard (/dev/input/event3, /dev/input/event4)\n" +
+		"KEY_A\nKEY_LEFTSHIFT\n#s-KEY_A\n" +
+		"# Virtual Keyboard (/dev/input/event20)\n" +
+		"KEY_B\n#KEY_B\n"
+	if !ok || stdout != want {
+		t.Errorf("got %v %q, want %q", ok, stdout, want)
+	}
+}
+
+func TestActiveKeysByAttribute(t *testing.T) {
+	usbDev, _ := setupGroupSysfs(t)
+	writeSysfsFiles(t, filepath.Join(usbDev, "1-2:1.0", "0003:046D:C31C.0001", "input", "input3"),
+		map[string]string{"id/bustype": "0003", "id/vendor": "046d", "id/product": "c31c"})
+	cleanup := setupMockDevices([]mockDeviceSpec{
+		{path: "/dev/input/event3", name: "Logitech USB Keyboard", supportedKeys: []int{30, 42}, activeKeys: []int{30}},
+		{path: "/dev/input/event20", name: "Virtual Keyboard", supportedKeys: []int{48}, activeKeys: []int{48}},
+	})
+	defer cleanup()
+	resetFlags()
+	defer resetFlags()
+
+	sel, err := parseFilter("vendor=046d bus=usb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ok bool
+	stdout, _, _ := captureOutput(func() {
+		ok = printActiveKeysFast(evutil.NewCombinedSelector().Add(sel), nil)
+	})
+	if want := "KEY_A\n#KEY_A\n"; !ok || stdout != want {
+		t.Errorf("got %v %q, want %q", ok, stdout, want)
+	}
+}
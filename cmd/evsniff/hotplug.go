@@ -0,0 +1,222 @@
+package main
+
+// Hotplug handling, shared by the uevent and the inotify sources: new device nodes are
+// matched against the FILTERs, opened and started; removed ones are reported with their names.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+	"github.com/omakoto/go-common/src/must"
+)
+
+// hotplugTag is the name of the hotplug source in the messages, e.g. "[uevent] CREATE: ...".
+var hotplugTag = "inotify"
+
+// retryInterval is the interval to retry opening devices that aren't accessible yet.
+const retryInterval = 1000 * time.Millisecond
+
+type hotplug struct {
+	col     colorizer
+	sel     evutil.Selector
+	r       *reactor
+	pending mapset.Set[string]
+	names   map[string]string // Path -> name of all the known devices, for the removal messages.
+
+	updatePending bool
+
+	starter     func(idev *evdev.InputDevice)
+	midiStarter func(idev *MidiDevice)
+}
+
+func newHotplug(r *reactor, col colorizer, sel evutil.Selector, starter func(idev *evdev.InputDevice), midiStarter func(idev *MidiDevice)) *hotplug {
+	h := &hotplug{
+		col:         col,
+		sel:         sel,
+		r:           r,
+		pending:     mapset.NewThreadUnsafeSet[string](),
+		names:       make(map[string]string),
+		starter:     starter,
+		midiStarter: midiStarter,
+	}
+	if devs, err := listSysfsDevices(); err == nil {
+		for _, d := range devs {
+			h.names[d.path] = d.name
+		}
+	}
+	files, _ := filepath.Glob("/dev/snd/midiC*D*")
+	cardNames := getCardNames()
+	for _, path := range files {
+		if card, device, err := parseMidiPath(path); err == nil {
+			h.names[path] = newMidiDevice(path, card, device, cardNames).name
+		}
+	}
+	return h
+}
+
+func (h *hotplug) printHotplug(action, path, name string) {
+	if *jsonOutput {
+		printJsonHotplug(action, path, name)
+		return
+	}
+	evCol := h.col.inotifyCreate()
+	if action == "DELETE" {
+		evCol = h.col.inotifyDelete()
+	}
+	nameStr := ""
+	if name != "" {
+		nameStr = " (" + name + ")"
+	}
+	fmt.Printf("[%s%s%s] %s%s%s: %s%s%s%s\n",
+		h.col.inotify(),
+		hotplugTag,
+		h.col.reset(),
+		evCol,
+		action,
+		h.col.reset(),
+		h.col.inotifyPath(),
+		path,
+		h.col.reset(),
+		nameStr,
+	)
+}
+
+// added is called when a device node is created. If ready is false, the device is opened after
+// a while, to give the driver and udev time to finish creating the device node and setting its
+// permissions.
+func (h *hotplug) added(path string, ready bool) {
+	h.printHotplug("CREATE", path, "")
+	h.pending.Add(path)
+	if ready {
+		h.update()
+	} else {
+		h.scheduleUpdate()
+	}
+}
+
+// removed is called when a device node is removed.
+func (h *hotplug) removed(path string) {
+	h.printHotplug("DELETE", path, h.names[path])
+	delete(h.names, path)
+	h.pending.Remove(path)
+	forgetDevice(path)
+	deviceRemoved(path)
+}
+
+// retry opens a pending device again, e.g. when udev changed its permissions.
+func (h *hotplug) retry(path string) {
+	if h.pending.Contains(path) {
+		h.update()
+	}
+}
+
+// scheduleUpdate opens the pending devices after a while.
+func (h *hotplug) scheduleUpdate() {
+	if h.updatePending {
+		return
+	}
+	h.updatePending = true
+	h.r.after(retryInterval, func() {
+		h.updatePending = false
+		h.update()
+	})
+}
+
+// retryLater adds path to retries if err is a permission error, which happens until udev
+// sets the permissions of new device nodes.
+func (h *hotplug) retryLater(path string, err error, retries mapset.Set[string]) bool {
+	if !os.IsPermission(err) {
+		return false
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "%s not ready to open yet...\n", path)
+	}
+	retries.Add(path)
+	return true
+}
+
+// update opens and starts the pending devices that are selected.
+func (h *hotplug) update() {
+	if h.pending.IsEmpty() {
+		return
+	}
+	if *verbose {
+		fmt.Printf("[updater] %v\n", h.pending)
+	}
+	retries := mapset.NewThreadUnsafeSet[string]()
+	for path := range h.pending.Iter() {
+		if *verbose {
+			fmt.Printf("%s\n", path)
+		}
+
+		if strings.HasPrefix(path, "/dev/snd/") {
+			card, device, err := parseMidiPath(path)
+			if err != nil {
+				continue
+			}
+			idev := newMidiDevice(path, card, device, getCardNames())
+			h.names[path] = idev.name
+			if !evutil.Matches(h.sel, idev) && !isRemovedDevice(idev) {
+				continue
+			}
+			idev.selectSubdevice(h.sel)
+			fd, err := openMidiDevice(path, idev.sub)
+			if err != nil {
+				if h.retryLater(path, err, retries) {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Failed to open %s: '%s'\n", path, err.Error())
+				continue
+			}
+			idev.fd = fd
+			checkReconnection(idev, h.col)
+			labelDevice(idev)
+			dumpMidiDevice(idev, "    ")
+			h.midiStarter(idev)
+		} else {
+			sd, sysfsErr := newSysfsDevice(path)
+			if sysfsErr == nil {
+				h.names[path] = sd.name
+				if !evutil.Matches(h.sel, sd) && !isRemovedDevice(sd) {
+					continue // Don't even open devices that aren't selected.
+				}
+			}
+			idev, err := evdev.Open(path)
+
+			if err != nil {
+				if h.retryLater(path, err, retries) {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Failed to open %s: '%s'\n", path, err.Error())
+				continue
+			}
+			if sysfsErr != nil {
+				nd := newNodeDevice(idev)
+				h.names[path] = must.Must2(nd.Name())
+				if !evutil.Matches(h.sel, nd) && !isRemovedDevice(nd) {
+					idev.Close()
+					continue
+				}
+				checkReconnection(nd, h.col)
+				labelDevice(nd)
+				dumpDevice(nd, "    ")
+			} else {
+				checkReconnection(sd, h.col)
+				labelDevice(sd)
+				dumpDevice(sd, "    ")
+				sd.close()
+			}
+			h.starter(idev)
+		}
+	}
+	h.pending = retries
+	if !h.pending.IsEmpty() {
+		h.scheduleUpdate()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+// Event rate tracking for --show-hz. Rates are tracked per device and per event type,
+// so interleaved devices (or a mouse's EV_REL and EV_MSC events) don't disturb each other.
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/go-common/src/utils"
+)
+
+const (
+	// hzWindow is the number of intervals the rolling min/avg/max and jitter are computed over.
+	hzWindow = 64
+
+	// hzMaxInterval is the longest interval that's considered part of a stream of events.
+	// Longer pauses start a new stream.
+	hzMaxInterval = 1.0
+
+	// hzSummaryInterval is how often the per-device summary is printed.
+	hzSummaryInterval = 5 * time.Second
+)
+
+type rateTracker struct {
+	lastTime  float64
+	intervals [hzWindow]float64 // Ring buffer of the last intervals, in seconds.
+	next      int
+	filled    int
+	events    int // Number of events since the last summary.
+}
+
+// add records an event at evTime (in seconds) and returns the current rate, or 0 if
+// there's no previous event to compute it from.
+func (r *rateTracker) add(evTime float64) float64 {
+	r.events++
+	delta := evTime - r.lastTime
+	first := r.lastTime == 0
+	if delta == 0 {
+		return 0 // Same packet.
+	}
+	r.lastTime = evTime
+	if first || delta < 0 || delta >= hzMaxInterval {
+		return 0
+	}
+
+	r.intervals[r.next] = delta
+	r.next = (r.next + 1) % hzWindow
+	r.filled = min(r.filled+1, hzWindow)
+	return 1 / delta
+}
+
+// stats returns the min/avg/max rates in Hz over the window, and the jitter (the standard
+// deviation of the intervals) in milliseconds.
+func (r *rateTracker) stats() (minHz, avgHz, maxHz, jitterMs float64, ok bool) {
+	if r.filled == 0 {
+		return 0, 0, 0, 0, false
+	}
+	minInterval := math.Inf(1)
+	maxInterval := 0.0
+	sum := 0.0
+	for _, d := range r.intervals[:r.filled] {
+		minInterval = min(minInterval, d)
+		maxInterval = max(maxInterval, d)
+		sum += d
+	}
+	mean := sum / float64(r.filled)
+
+	variance := 0.0
+	for _, d := range r.intervals[:r.filled] {
+		variance += (d - mean) * (d - mean)
+	}
+	variance /= float64(r.filled)
+
+	return 1 / maxInterval, 1 / mean, 1 / minInterval, math.Sqrt(variance) * 1000, true
+}
+
+type deviceRates struct {
+	name  string
+	types map[evdev.EvType]*rateTracker
+}
+
+// path -> rates
+var rateTrackers = make(map[string]*deviceRates)
+
+// trackRate records e and returns the string to show after the event, e.g.
+// " (125hz, 124/125/126hz, jitter 0.05ms)".
+func trackRate(e *evdev.InputEvent, path, name string) string {
+	dr := rateTrackers[path]
+	if dr == nil {
+		dr = &deviceRates{types: make(map[evdev.EvType]*rateTracker)}
+		rateTrackers[path] = dr
+	}
+	dr.name = name
+	r := dr.types[e.Type]
+	if r == nil {
+		r = &rateTracker{}
+		dr.types[e.Type] = r
+	}
+
+	evTime := float64(e.Time.Sec) + float64(e.Time.Usec)/1_000_000.0
+	hz := r.add(evTime)
+	if hz == 0 {
+		return ""
+	}
+	minHz, avgHz, maxHz, jitter, _ := r.stats()
+	return fmt.Sprintf(" (%dhz, min/avg/max %d/%d/%dhz, jitter %.2fms)",
+		int64(hz), int64(minHz), int64(avgHz), int64(maxHz), jitter)
+}
+
+// printRateSummaries prints one line per device and event type that had events since the last call.
+func printRateSummaries(col colorizer) {
+	for path, dr := range utils.SortedMap(rateTrackers) {
+		for t, r := range utils.SortedMap(dr.types) {
+			if r.events == 0 {
+				continue
+			}
+			events := r.events
+			r.events = 0
+
+			minHz, avgHz, maxHz, jitter, ok := r.stats()
+			if !ok {
+				continue
+			}
+			fmt.Printf("%s# Rate %s (%s) %s: %d events, min/avg/max %d/%d/%dhz, jitter %.2fms%s\n",
+				col.deviceLine(),
+				path,
+				dr.name,
+				evdev.TypeName(t),
+				events,
+				int64(minHz), int64(avgHz), int64(maxHz),
+				jitter,
+				col.reset(),
+			)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRateTracker(t *testing.T) {
+	r := &rateTracker{}
+
+	if hz := r.add(10.0); hz != 0 {
+		t.Errorf("first event: got %v hz, want 0", hz)
+	}
+	if hz := r.add(10.0); hz != 0 {
+		t.Errorf("same packet: got %v hz, want 0", hz)
+	}
+	if hz := r.add(10.008); math.Abs(hz-125) > 0.01 {
+		t.Errorf("got %v hz, want 125", hz)
+	}
+	if hz := r.add(10.012); math.Abs(hz-250) > 0.01 {
+		t.Errorf("got %v hz, want 250", hz)
+	}
+	// A pause starts a new stream, which isn't part of the stats.
+	if hz := r.add(15.0); hz != 0 {
+		t.Errorf("after a pause: got %v hz, want 0", hz)
+	}
+
+	minHz, avgHz, maxHz, jitter, ok := r.stats()
+	if !ok {
+		t.Fatal("no stats")
+	}
+	if math.Abs(minHz-125) > 0.01 || math.Abs(maxHz-250) > 0.01 || math.Abs(avgHz-1/0.006) > 0.01 {
+		t.Errorf("got min/avg/max %v/%v/%v", minHz, avgHz, maxHz)
+	}
+	if math.Abs(jitter-2) > 0.001 {
+		t.Errorf("got jitter %vms, want 2ms", jitter)
+	}
+	if r.events != 5 {
+		t.Errorf("got %d events, want 5", r.events)
+	}
+}
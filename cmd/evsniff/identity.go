@@ -0,0 +1,139 @@
+package main
+
+// Stable device identity. eventN numbers change when a device is unplugged and plugged in
+// again, but udev's /dev/input/by-id and /dev/input/by-path symlinks, and the IDs, the name
+// and the serial number of the device, don't.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+// devLinkDirs are the directories of the symlinks to the device nodes, which udev maintains.
+var devLinkDirs = []string{devInput + "/by-id", devInput + "/by-path"}
+
+// deviceAliases returns the symlinks in devLinkDirs that point to path.
+func deviceAliases(path string) []string {
+	ret := make([]string, 0)
+	for _, dir := range devLinkDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			link := filepath.Join(dir, e.Name())
+			if target, err := filepath.EvalSymlinks(link); err == nil && target == path {
+				ret = append(ret, link)
+			}
+		}
+	}
+	return ret
+}
+
+var (
+	_ evutil.AliasDevice = (*sysfsDevice)(nil)
+	_ evutil.AliasDevice = (*nodeDevice)(nil)
+)
+
+func (d *sysfsDevice) Aliases() []string {
+	if d.aliases == nil {
+		d.aliases = deviceAliases(d.path)
+	}
+	return d.aliases
+}
+
+func (d *nodeDevice) Aliases() []string {
+	if d.aliases == nil {
+		d.aliases = deviceAliases(d.Path())
+	}
+	return d.aliases
+}
+
+// deviceIdentity returns what identifies a physical device across reconnections: the IDs,
+// the name, the serial number and the interface, i.e. "input1" of the phys, for devices
+// with multiple nodes. The USB port isn't included, so moving a device to another port is
+// still a reconnection. Identical devices without a serial number share an identity.
+func deviceIdentity(d evutil.IDDevice) string {
+	id, _ := d.InputID()
+	name, _ := d.Name()
+	var uniq, iface string
+	if ud, ok := d.(evutil.UniqDevice); ok {
+		uniq, _ = ud.UniqueID()
+	}
+	if pd, ok := d.(evutil.PhysDevice); ok {
+		if phys, _ := pd.PhysicalLocation(); strings.Contains(phys, "/") {
+			iface = phys[strings.LastIndexByte(phys, '/')+1:]
+		}
+	}
+	return fmt.Sprintf("%04x:%04x:%04x:%s:%s:%s", id.BusType, id.Vendor, id.Product, name, uniq, iface)
+}
+
+var (
+	deviceIdentities = make(map[string]string)   // Path -> identity of the selected devices.
+	removedDevices   = make(map[string][]string) // Identity -> paths of the removed devices, oldest first.
+)
+
+// rememberDevice records the identity of a selected device, to recognize it when it's reconnected.
+func rememberDevice(d evutil.IDDevice) {
+	deviceIdentities[d.Path()] = deviceIdentity(d)
+}
+
+// forgetDevice is called when the device node at path is removed.
+func forgetDevice(path string) {
+	if id, ok := deviceIdentities[path]; ok {
+		delete(deviceIdentities, path)
+		removedDevices[id] = append(removedDevices[id], path)
+	}
+}
+
+// reconnectedFrom returns the previous path of d, if d is a device that was removed before.
+// When several identical devices were removed, they're taken in the order they were removed.
+func reconnectedFrom(d evutil.IDDevice) (string, bool) {
+	id := deviceIdentity(d)
+	paths := removedDevices[id]
+	if len(paths) == 0 {
+		return "", false
+	}
+	if len(paths) == 1 {
+		delete(removedDevices, id)
+	} else {
+		removedDevices[id] = paths[1:]
+	}
+	return paths[0], true
+}
+
+// isRemovedDevice returns whether d is a selected device that was removed. It's watched again
+// when it comes back, even if the FILTERs don't match its new path.
+func isRemovedDevice(d evutil.IDDevice) bool {
+	_, ok := removedDevices[deviceIdentity(d)]
+	return ok
+}
+
+// checkReconnection remembers d, and prints a notice if it's a device that was removed before.
+func checkReconnection(d evutil.IDDevice, col colorizer) {
+	prev, ok := reconnectedFrom(d)
+	rememberDevice(d)
+	if !ok {
+		return
+	}
+	deviceReconnected(prev)
+	if *jsonOutput {
+		printJsonReconnect(d.Path(), prev)
+		return
+	}
+	fmt.Printf("[%s%s%s] %sRECONNECT%s: %s%s%s (was %s)\n",
+		col.inotify(),
+		hotplugTag,
+		col.reset(),
+		col.inotifyCreate(),
+		col.reset(),
+		col.inotifyPath(),
+		d.Path(),
+		col.reset(),
+		prev,
+	)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+func TestDeviceAliases(t *testing.T) {
+	orig := devLinkDirs
+	defer func() { devLinkDirs = orig }()
+	dir := t.TempDir()
+	devLinkDirs = []string{filepath.Join(dir, "by-id"), filepath.Join(dir, "by-path")}
+
+	node := filepath.Join(dir, "event5")
+	other := filepath.Join(dir, "event6")
+	for _, f := range []string{node, other} {
+		if err := os.WriteFile(f, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"by-id/usb-Logitech_USB_Keyboard-event-kbd":        "../event5",
+		"by-path/pci-0000:00:14.0-usb-0:2:1.0-event-kbd":   "../event5",
+		"by-id/usb-Logitech_USB_Receiver-if01-event-mouse": "../event6",
+	}
+	for _, d := range devLinkDirs {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &sysfsDevice{path: node, name: "Logitech USB Keyboard"}
+	want := []string{
+		filepath.Join(dir, "by-id/usb-Logitech_USB_Keyboard-event-kbd"),
+		filepath.Join(dir, "by-path/pci-0000:00:14.0-usb-0:2:1.0-event-kbd"),
+	}
+	if got := d.Aliases(); !slices.Equal(got, want) {
+		t.Errorf("Aliases() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filepath.Join(dir, "by-id/usb-Logitech_USB_Keyboard-event-kbd"), true},
+		{filepath.Join(dir, "by-id/usb-Logitech_*-event-kbd"), true},
+		{filepath.Join(dir, "by-path/*-usb-0:2:*"), true},
+		{filepath.Join(dir, "event[45]"), true},
+		{filepath.Join(dir, "by-id/usb-Logitech_USB_Receiver-if01-event-mouse"), false},
+		{filepath.Join(dir, "by-id/*-event-mouse"), false},
+	}
+	for _, tt := range tests {
+		sel := evutil.NewCombinedSelector().Add(evutil.NewPathSelector(tt.filter))
+		if got := evutil.Matches(sel, d); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+
+	// Symlinks outside devLinkDirs are resolved.
+	d = &sysfsDevice{path: other, name: "Logitech USB Receiver"}
+	link := filepath.Join(dir, "mymouse")
+	if err := os.Symlink("event6", link); err != nil {
+		t.Fatal(err)
+	}
+	if !evutil.Matches(evutil.NewCombinedSelector().Add(evutil.NewPathSelector(link)), d) {
+		t.Errorf("%s doesn't match %s", link, other)
+	}
+}
+
+func TestReconnection(t *testing.T) {
+	resetFlags()
+	clear(deviceIdentities)
+	clear(removedDevices)
+	defer clear(deviceIdentities)
+	defer clear(removedDevices)
+
+	newDev := func(path, phys string) *sysfsDevice {
+		d := &sysfsDevice{path: path, name: "Logitech USB Receiver", phys: phys, uniq: "1234"}
+		d.id.Vendor = 0x046d
+		d.id.Product = 0xc52b
+		return d
+	}
+	rememberDevice(newDev("/dev/input/event5", "usb-0000:00:14.0-2/input0"))
+	rememberDevice(newDev("/dev/input/event6", "usb-0000:00:14.0-2/input1"))
+	forgetDevice("/dev/input/event5")
+	forgetDevice("/dev/input/event6")
+	forgetDevice("/dev/input/event9") // Not selected.
+
+	// Plugged into another port.
+	stdout, _, _ := captureOutput(func() {
+		checkReconnection(newDev("/dev/input/event12", "usb-0000:00:14.0-3/input1"), &noColorizer{})
+		checkReconnection(newDev("/dev/input/event11", "usb-0000:00:14.0-3/input0"), &noColorizer{})
+		checkReconnection(newDev("/dev/input/event13", "usb-0000:00:14.0-3/input2"), &noColorizer{})
+	})
+	want := []string{
+		"[inotify] RECONNECT: /dev/input/event12 (was /dev/input/event6)",
+		"[inotify] RECONNECT: /dev/input/event11 (was /dev/input/event5)",
+	}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(removedDevices) != 0 || len(deviceIdentities) != 3 {
+		t.Errorf("removedDevices = %v, deviceIdentities = %v", removedDevices, deviceIdentities)
+	}
+}
+
+func TestReconnectionWithoutSerial(t *testing.T) {
+	resetFlags()
+	clear(deviceIdentities)
+	clear(removedDevices)
+	defer clear(deviceIdentities)
+	defer clear(removedDevices)
+
+	// Two identical mice without serial numbers.
+	newDev := func(path, phys string) *sysfsDevice {
+		d := &sysfsDevice{path: path, name: "PixArt USB Optical Mouse", phys: phys}
+		d.id.Vendor = 0x093a
+		d.id.Product = 0x2510
+		return d
+	}
+	rememberDevice(newDev("/dev/input/event5", "usb-0000:00:14.0-2/input0"))
+	rememberDevice(newDev("/dev/input/event6", "usb-0000:00:14.0-3/input0"))
+	forgetDevice("/dev/input/event6")
+	forgetDevice("/dev/input/event5")
+
+	stdout, _, _ := captureOutput(func() {
+		checkReconnection(newDev("/dev/input/event11", "usb-0000:00:14.0-3/input0"), &noColorizer{})
+		checkReconnection(newDev("/dev/input/event12", "usb-0000:00:14.0-2/input0"), &noColorizer{})
+		checkReconnection(newDev("/dev/input/event13", "usb-0000:00:14.0-4/input0"), &noColorizer{})
+	})
+	want := []string{
+		"[inotify] RECONNECT: /dev/input/event11 (was /dev/input/event6)",
+		"[inotify] RECONNECT: /dev/input/event12 (was /dev/input/event5)",
+	}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(removedDevices) != 0 {
+		t.Errorf("removedDevices = %v", removedDevices)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+// Helpers for issuing ioctls on raw file descriptors.
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	iocNone  = 0
+	iocWrite = 1
+	iocRead  = 2
+)
+
+// ioc builds an ioctl request code, like the _IOC() macro.
+func ioc(dir, typ, nr, size uint32) uint32 {
+	return (dir << 30) | (size << 16) | (typ << 8) | nr
+}
+
+func doRawIoctl(fd uintptr, code uint32, ptr unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(code), uintptr(ptr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func doRawIoctlValue(fd uintptr, code uint32, value uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(code), value)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
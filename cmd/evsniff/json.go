@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// Records emitted by --json. Each record is written as a single line, so the output
+// can be consumed as JSON Lines (e.g. by jq).
+
+type jsonEvdevEvent struct {
+	Kind     string `json:"kind"`
+	Sec      int64  `json:"sec"`
+	Usec     int64  `json:"usec"`
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Vendor   uint16 `json:"vendor"`
+	Product  uint16 `json:"product"`
+	Type     uint16 `json:"type"`
+	TypeName string `json:"type_name"`
+	Code     uint16 `json:"code"`
+	CodeName string `json:"code_name"`
+	Value    int32  `json:"value"`
+}
+
+type jsonMidiEvent struct {
+	Kind    string `json:"kind"`
+	Sec     int64  `json:"sec"`
+	Usec    int64  `json:"usec"`
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Vendor  uint16 `json:"vendor"`
+	Product uint16 `json:"product"`
+	Type    string `json:"type"`
+	Status  byte   `json:"status"`
+	Channel byte   `json:"channel,omitempty"`
+	Data1   byte   `json:"data1"`
+	Data2   byte   `json:"data2"`
+	SysEx   []int  `json:"sysex,omitempty"`
+}
+
+type jsonMidiMeta struct {
+	Kind string `json:"kind"`
+	Sec  int64  `json:"sec"`
+	Usec int64  `json:"usec"`
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type jsonDevice struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Vendor  uint16 `json:"vendor"`
+	Product uint16 `json:"product"`
+}
+
+type jsonHotplug struct {
+	Kind     string `json:"kind"`
+	Action   string `json:"action"`
+	Path     string `json:"path"`
+	Name     string `json:"name,omitempty"`     // The name of a removed device.
+	Previous string `json:"previous,omitempty"` // The previous path of a reconnected device.
+}
+
+type jsonLifecycle struct {
+	Kind     string  `json:"kind"`
+	Action   string  `json:"action"`
+	Path     string  `json:"path"`
+	Name     string  `json:"name"`
+	State    string  `json:"state"`
+	Error    string  `json:"error,omitempty"`
+	Events   int     `json:"events"`
+	Duration float64 `json:"duration"` // In seconds.
+}
+
+func printJson(v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode JSON: %v\n", err)
+		return
+	}
+	os.Stdout.Write(append(b, '\n'))
+}
+
+func printJsonEvdevEvent(e *evdev.InputEvent, path string, id evdev.InputID, name string) {
+	printJson(&jsonEvdevEvent{
+		Kind:     "evdev",
+		Sec:      e.Time.Sec,
+		Usec:     e.Time.Usec,
+		Path:     path,
+		Name:     name,
+		Vendor:   id.Vendor,
+		Product:  id.Product,
+		Type:     uint16(e.Type),
+		TypeName: e.TypeName(),
+		Code:     uint16(e.Code),
+		CodeName: e.CodeName(),
+		Value:    e.Value,
+	})
+}
+
+func printJsonMidiEvent(ev MidiEvent, d *MidiDevice) {
+	var sysex []int
+	if ev.SysEx != nil {
+		sysex = make([]int, len(ev.SysEx))
+		for i, b := range ev.SysEx {
+			sysex[i] = int(b)
+		}
+	}
+	printJson(&jsonMidiEvent{
+		Kind:    "midi",
+		Sec:     ev.Timestamp.Unix(),
+		Usec:    int64(ev.Timestamp.Nanosecond() / int(time.Microsecond)),
+		Path:    d.path,
+		Name:    d.name,
+		Vendor:  d.vendor,
+		Product: d.product,
+		Type:    ev.Type,
+		Status:  ev.Status,
+		Channel: ev.Channel,
+		Data1:   ev.Data1,
+		Data2:   ev.Data2,
+		SysEx:   sysex,
+	})
+}
+
+func printJsonReconnect(path, previous string) {
+	printJson(&jsonHotplug{
+		Kind:     "hotplug",
+		Action:   "RECONNECT",
+		Path:     path,
+		Previous: previous,
+	})
+}
+
+func printJsonMidiMeta(ts time.Time, d *MidiDevice, typ, text string) {
+	printJson(&jsonMidiMeta{
+		Kind: "meta",
+		Sec:  ts.Unix(),
+		Usec: int64(ts.Nanosecond() / int(time.Microsecond)),
+		Path: d.path,
+		Name: d.name,
+		Type: typ,
+		Text: text,
+	})
+}
+
+func printJsonLifecycle(action string, l *deviceLifecycle, duration time.Duration) {
+	var errStr string
+	if l.err != nil {
+		errStr = l.err.Error()
+	}
+	printJson(&jsonLifecycle{
+		Kind:     "lifecycle",
+		Action:   action,
+		Path:     l.path,
+		Name:     l.name,
+		State:    l.state.String(),
+		Error:    errStr,
+		Events:   l.events,
+		Duration: duration.Round(time.Millisecond).Seconds(),
+	})
+}
+
+func printJsonDevice(path, name string, vendor, product uint16) {
+	printJson(&jsonDevice{
+		Kind:    "device",
+		Path:    path,
+		Name:    name,
+		Vendor:  vendor,
+		Product: product,
+	})
+}
+
+func printJsonHotplug(action, path, name string) {
+	printJson(&jsonHotplug{
+		Kind:   "hotplug",
+		Action: action,
+		Path:   path,
+		Name:   name,
+	})
+}
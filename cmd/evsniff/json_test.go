@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestPrintJsonMidiEvent(t *testing.T) {
+	d := &MidiDevice{path: "/dev/snd/midiC1D0", name: "Mock Keyboard", vendor: 0x1234, product: 0x5678}
+	ev := MidiEvent{
+		Timestamp: time.Unix(100, 250_000_000),
+		Status:    0xF0,
+		SysEx:     []byte{0xF0, 0x7E, 0xF7},
+		Type:      "SysEx",
+	}
+
+	stdout, _, panicVal := captureOutput(func() {
+		printJsonMidiEvent(ev, d)
+	})
+	if panicVal != nil {
+		t.Fatalf("unexpected panic: %v", panicVal)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stdout)), &got); err != nil {
+		t.Fatalf("output %q is not valid JSON: %v", stdout, err)
+	}
+	if got["kind"] != "midi" || got["type"] != "SysEx" || got["path"] != "/dev/snd/midiC1D0" {
+		t.Errorf("unexpected record: %v", got)
+	}
+	if got["sec"] != float64(100) || got["usec"] != float64(250000) {
+		t.Errorf("unexpected timestamp: sec=%v usec=%v", got["sec"], got["usec"])
+	}
+	sysex, ok := got["sysex"].([]any)
+	if !ok || len(sysex) != 3 || sysex[1] != float64(0x7E) {
+		t.Errorf("unexpected sysex: %v", got["sysex"])
+	}
+}
+
+// decodeJsonLines decodes the records printed by f.
+func decodeJsonLines(t *testing.T, f func()) []map[string]any {
+	t.Helper()
+	stdout, _, panicVal := captureOutput(f)
+	if panicVal != nil {
+		t.Fatalf("unexpected panic: %v", panicVal)
+	}
+	var ret []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		var got map[string]any
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("output %q is not valid JSON: %v", line, err)
+		}
+		ret = append(ret, got)
+	}
+	return ret
+}
+
+func TestPrintJsonEvdevEvent(t *testing.T) {
+	e := &evdev.InputEvent{
+		Time:  syscall.Timeval{Sec: 1712345678, Usec: 123456},
+		Type:  evdev.EV_KEY,
+		Code:  evdev.KEY_A,
+		Value: 1,
+	}
+	got := decodeJsonLines(t, func() {
+		printJsonEvdevEvent(e, "/dev/input/event3", evdev.InputID{Vendor: 0x046d, Product: 0xc31c}, "Logitech USB Keyboard")
+	})
+	want := []map[string]any{{
+		"kind":      "evdev",
+		"sec":       float64(1712345678),
+		"usec":      float64(123456),
+		"path":      "/dev/input/event3",
+		"name":      "Logitech USB Keyboard",
+		"vendor":    float64(0x046d),
+		"product":   float64(0xc31c),
+		"type":      float64(1),
+		"type_name": "EV_KEY",
+		"code":      float64(30),
+		"code_name": "KEY_A",
+		"value":     float64(1),
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPrintJsonHotplug(t *testing.T) {
+	got := decodeJsonLines(t, func() {
+		printJsonHotplug("CREATE", "/dev/input/event7", "")
+		printJsonHotplug("DELETE", "/dev/input/event7", "PCsensor FootSwitch")
+		printJsonReconnect("/dev/input/event8", "/dev/input/event7")
+	})
+	want := []map[string]any{
+		{"kind": "hotplug", "action": "CREATE", "path": "/dev/input/event7"},
+		{"kind": "hotplug", "action": "DELETE", "path": "/dev/input/event7", "name": "PCsensor FootSwitch"},
+		{"kind": "hotplug", "action": "RECONNECT", "path": "/dev/input/event8", "previous": "/dev/input/event7"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+// Device lifecycle. Each watched device goes through:
+//
+//	opening -> active -> removed (unplugged)
+//	                  -> error (read error) -> opening (reattached) -> active ...
+//
+// A removed device comes back through hotplug, as a reconnection. A device that had a read
+// error but is still there, e.g. a Bluetooth keyboard that went to sleep, is reopened with
+// an increasing delay.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+type lifecycleState int
+
+const (
+	stateOpening lifecycleState = iota
+	stateActive
+	stateRemoved
+	stateError
+)
+
+var lifecycleStateNames = [...]string{"opening", "active", "removed", "error"}
+
+func (s lifecycleState) String() string {
+	return lifecycleStateNames[s]
+}
+
+// maxReattachDelay is the maximum interval between attempts to reopen a device after an error.
+const maxReattachDelay = 30 * time.Second
+
+// deviceLifecycle tracks a watched device node.
+type deviceLifecycle struct {
+	path  string
+	name  string
+	state lifecycleState
+	err   error // The error that disconnected the device.
+
+	since  time.Time // When the device became active.
+	events int       // The number of events read since then.
+
+	// disconnectedAt is set when the device is disconnected, until it's reattached.
+	disconnectedAt time.Time
+
+	r          *reactor
+	col        colorizer
+	reopen     func() error // Reopens the device after an error.
+	retryDelay time.Duration
+
+	// held is set while the device keeps the reactor running, because it can come back.
+	held bool
+}
+
+// hotplugActive is set when hotplug is watching for devices, which can bring back removed devices.
+var hotplugActive bool
+
+var lifecycles = make(map[string]*deviceLifecycle) // Path -> lifecycle of the watched devices.
+
+// openingDevice returns the lifecycle of the device at path, which is being opened.
+func openingDevice(r *reactor, col colorizer, path string, reopen func() error) *deviceLifecycle {
+	l, ok := lifecycles[path]
+	if !ok || l.state != stateOpening {
+		// A new device, or one that came back through hotplug, rather than being reopened
+		// by scheduleReattach.
+		if ok {
+			l.setHeld(false)
+		}
+		l = &deviceLifecycle{path: path}
+		lifecycles[path] = l
+	}
+	l.state = stateOpening
+	l.r = r
+	l.col = col
+	l.reopen = reopen
+	return l
+}
+
+// activate is called when the device has been opened and is being watched.
+func (l *deviceLifecycle) activate(name string) {
+	l.name = name
+	l.state = stateActive
+	l.err = nil
+	l.since = time.Now()
+	l.events = 0
+	l.retryDelay = 0
+	l.setHeld(false)
+	if !l.disconnectedAt.IsZero() {
+		down := time.Since(l.disconnectedAt)
+		l.printNotice("REATTACH", "after "+durationString(down), down)
+		l.disconnectedAt = time.Time{}
+	}
+}
+
+// failed is called when the device couldn't be opened or watched.
+func (l *deviceLifecycle) failed(err error) {
+	if l.disconnectedAt.IsZero() {
+		l.state = stateError
+		l.err = err
+		return // Failed on the first attempt; there's nothing to reattach.
+	}
+	l.retry(err)
+}
+
+// disconnected is called when reading from the device fails. It prints a notice, and if the
+// device node is still there, tries to reopen it later.
+func (l *deviceLifecycle) disconnected(err error) {
+	l.err = err
+	l.disconnectedAt = time.Now()
+	if isRemovedError(err, l.path) {
+		l.state = stateRemoved
+	} else {
+		l.state = stateError
+	}
+	l.printDisconnect()
+	if l.state == stateError {
+		l.scheduleReattach()
+	} else {
+		l.setHeld(hotplugActive)
+	}
+}
+
+// deviceRemoved is called by hotplug when the device node at path is removed, after which
+// there's no point in reopening it.
+func deviceRemoved(path string) {
+	if l, ok := lifecycles[path]; ok && l.state == stateError {
+		l.state = stateRemoved
+		l.setHeld(hotplugActive)
+	}
+}
+
+// deviceReconnected is called by hotplug when a removed device comes back at another path, so
+// the lifecycle at its previous path doesn't wait for it any more.
+func deviceReconnected(prev string) {
+	if l, ok := lifecycles[prev]; ok && l.state == stateRemoved {
+		l.setHeld(false)
+	}
+}
+
+// setHeld keeps the reactor running while the device can come back: until it's reattached, or
+// while it's removed and hotplug can pick it up again. Otherwise, the loop would exit as soon as
+// the last device is disconnected.
+func (l *deviceLifecycle) setHeld(held bool) {
+	if held == l.held {
+		return
+	}
+	l.held = held
+	if held {
+		l.r.hold()
+	} else {
+		l.r.release()
+	}
+}
+
+// isRemovedError returns whether err means the device has been unplugged.
+func isRemovedError(err error, path string) bool {
+	if errors.Is(err, syscall.ENODEV) {
+		return true
+	}
+	_, statErr := os.Stat(path)
+	return os.IsNotExist(statErr)
+}
+
+func (l *deviceLifecycle) scheduleReattach() {
+	if l.retryDelay == 0 {
+		l.retryDelay = retryInterval
+	} else {
+		l.retryDelay = min(l.retryDelay*2, maxReattachDelay)
+	}
+	l.setHeld(true)
+	l.r.after(l.retryDelay, func() {
+		if lifecycles[l.path] != l || l.state != stateError {
+			return // Removed, or reopened by hotplug.
+		}
+		if *verbose {
+			fmt.Printf("Reopening %s...\n", l.path)
+		}
+		l.state = stateOpening
+		if err := l.reopen(); err != nil {
+			l.retry(err)
+		}
+	})
+}
+
+// retry is called when reopening the device failed.
+func (l *deviceLifecycle) retry(err error) {
+	l.err = err
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENODEV) {
+		l.state = stateRemoved // Hotplug will pick it up if it comes back.
+		l.setHeld(hotplugActive)
+		return
+	}
+	l.state = stateError
+	l.scheduleReattach()
+}
+
+func durationString(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+func (l *deviceLifecycle) printDisconnect() {
+	duration := time.Since(l.since)
+	detail := fmt.Sprintf("%v, %d events in %s", l.state, l.events, durationString(duration))
+	if l.state == stateError {
+		detail = fmt.Sprintf("error: %v, %d events in %s; reattaching", l.err, l.events, durationString(duration))
+	}
+	l.printNotice("DISCONNECT", detail, duration)
+}
+
+// printNotice prints a lifecycle change. duration is how long the device was active for
+// DISCONNECT, or disconnected for REATTACH.
+func (l *deviceLifecycle) printNotice(action, detail string, duration time.Duration) {
+	if *jsonOutput {
+		printJsonLifecycle(action, l, duration)
+		return
+	}
+	col := l.col
+	actionCol := col.inotifyCreate()
+	if action == "DISCONNECT" {
+		actionCol = col.inotifyDelete()
+	}
+	fmt.Printf("[%slifecycle%s] %s%s%s: %s%s%s (%s): %s\n",
+		col.inotify(),
+		col.reset(),
+		actionCol,
+		action,
+		col.reset(),
+		col.inotifyPath(),
+		l.path,
+		col.reset(),
+		l.name,
+		detail,
+	)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	resetFlags()
+	clear(lifecycles)
+	defer clear(lifecycles)
+
+	r, err := newReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := &noColorizer{}
+	dir := t.TempDir()
+
+	// A device that goes away.
+	removed := filepath.Join(dir, "event5")
+	l := openingDevice(r, col, removed, func() error {
+		t.Error("reopened a removed device")
+		return nil
+	})
+	l.activate("Logitech USB Receiver")
+	l.since = time.Now().Add(-90 * time.Second)
+	l.events = 1234
+
+	// A device that fails to read, but is still there.
+	sleeping := filepath.Join(dir, "event6")
+	if err := os.WriteFile(sleeping, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	var reopen func() error
+	reopen = func() error {
+		attempts++
+		if attempts == 1 {
+			return syscall.EIO
+		}
+		openingDevice(r, col, sleeping, reopen).activate("BT Keyboard")
+		return nil
+	}
+	s := openingDevice(r, col, sleeping, reopen)
+	s.activate("BT Keyboard")
+	s.since = time.Now().Add(-2 * time.Hour)
+	s.events = 42
+
+	stdout, _, _ := captureOutput(func() {
+		l.disconnected(syscall.ENODEV)
+		s.disconnected(syscall.EIO)
+
+		// The first attempt fails, and the second one succeeds, with a longer delay.
+		for i, want := range []time.Duration{retryInterval, 2 * retryInterval} {
+			if len(r.timers) != 1 {
+				t.Fatalf("attempt %d: %d timers", i, len(r.timers))
+			}
+			timer := r.timers[0]
+			r.timers = nil
+			if d := time.Until(timer.deadline); d > want || d < want-time.Second/2 {
+				t.Errorf("attempt %d: delay = %v, want %v", i, d, want)
+			}
+			timer.f()
+		}
+	})
+	want := []string{
+		"[lifecycle] DISCONNECT: " + removed + " (Logitech USB Receiver): removed, 1234 events in 1m30s",
+		"[lifecycle] DISCONNECT: " + sleeping + " (BT Keyboard): error: input/output error, 42 events in 2h0m0s; reattaching",
+		"[lifecycle] REATTACH: " + sleeping + " (BT Keyboard): after 0s",
+	}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if l.state != stateRemoved || s.state != stateActive || s.events != 0 || len(r.timers) != 0 {
+		t.Errorf("states = %v, %v, events = %d, %d timers", l.state, s.state, s.events, len(r.timers))
+	}
+
+	// Removal stops the attempts.
+	_, _, _ = captureOutput(func() {
+		s.disconnected(syscall.EIO)
+	})
+	deviceRemoved(sleeping)
+	r.timers[0].f()
+	if s.state != stateRemoved || attempts != 2 {
+		t.Errorf("state = %v, attempts = %d", s.state, attempts)
+	}
+}
+
+// pipeDevice is a device read from a pipe, which fails when the pipe is closed.
+type pipeDevice struct {
+	rfd int
+	lc  *deviceLifecycle
+}
+
+func (p *pipeDevice) fd() int {
+	return p.rfd
+}
+
+func (p *pipeDevice) onReadable() error {
+	var buf [16]byte
+	n, err := syscall.Read(p.rfd, buf[:])
+	if err == nil && n == 0 {
+		err = syscall.EIO
+	}
+	if err != nil {
+		p.lc.disconnected(err)
+		return err
+	}
+	p.lc.events += n
+	return nil
+}
+
+func (p *pipeDevice) close() {
+	_ = syscall.Close(p.rfd)
+}
+
+func TestLifecycleRun(t *testing.T) {
+	resetFlags()
+	clear(lifecycles)
+	defer clear(lifecycles)
+
+	r, err := newReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := &noColorizer{}
+	path := filepath.Join(t.TempDir(), "event6")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each time the device is opened, it gets one event and then fails. It's gone after
+	// the second time.
+	opened := 0
+	var start func() error
+	start = func() error {
+		opened++
+		var p [2]int
+		if err := syscall.Pipe2(p[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+			return err
+		}
+		_, _ = syscall.Write(p[1], []byte{1})
+		_ = syscall.Close(p[1])
+		if opened == 2 {
+			_ = os.Remove(path)
+		}
+		s := &pipeDevice{rfd: p[0], lc: openingDevice(r, col, path, start)}
+		if err := r.add(s); err != nil {
+			return err
+		}
+		s.lc.activate("BT Keyboard")
+		return nil
+	}
+
+	stdout, _, _ := captureOutput(func() {
+		if err := start(); err != nil {
+			t.Error(err)
+		}
+		done := make(chan bool)
+		go func() {
+			r.run()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the loop didn't exit")
+		}
+	})
+	want := []string{
+		"[lifecycle] DISCONNECT: " + path + " (BT Keyboard): error: input/output error, 1 events in 0s; reattaching",
+		"[lifecycle] REATTACH: " + path + " (BT Keyboard): after 1s",
+		"[lifecycle] DISCONNECT: " + path + " (BT Keyboard): removed, 1 events in 0s",
+	}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if opened != 2 || r.holds != 0 {
+		t.Errorf("opened %d times, %d holds", opened, r.holds)
+	}
+}
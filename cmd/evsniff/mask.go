@@ -0,0 +1,108 @@
+package main
+
+// Kernel-side event filtering. Events we'd drop anyway (--no-rel, --no-abs, MSC_SCAN, and the
+// event types that --where can't match) are masked with EVIOCSMASK, so the kernel doesn't even
+// queue them for us. This saves a lot of wakeups with touchpads and tablets, which send hundreds
+// of EV_ABS events per second.
+//
+// EVIOCSMASK is only available since Linux 4.4; on older kernels, handleEvent still drops the
+// events. Filtering in handleEvent stays in place in any case, for replayed and imported events.
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+// struct input_mask
+type inputMask struct {
+	Type      uint32
+	CodesSize uint32
+	CodesPtr  uint64
+}
+
+var evioCSMask = ioc(iocWrite, 'E', 0x93, uint32(unsafe.Sizeof(inputMask{})))
+
+// allBits returns a bitmask with bits 0 to maxBit set.
+func allBits(maxBit int) []byte {
+	ret := make([]byte, maxBit/8+1)
+	for i := 0; i <= maxBit; i++ {
+		ret[i/8] |= 1 << (i % 8)
+	}
+	return ret
+}
+
+func clearBit(bits []byte, bit int) {
+	bits[bit/8] &^= 1 << (bit % 8)
+}
+
+// kernelMasks returns the masks to set with EVIOCSMASK, by event type, or nil if all events
+// are needed. Type EV_SYN is the mask of event types.
+func kernelMasks() map[evdev.EvType][]byte {
+	if *recordFile != "" {
+		return nil // Recordings contain everything.
+	}
+	ret := make(map[evdev.EvType][]byte)
+
+	types := allBits(evdev.EV_MAX)
+	masked := false
+	for t := evdev.EvType(1); t <= evdev.EV_MAX; t++ { // EV_SYN is always needed.
+		if (*noRel && t == evdev.EV_REL) || (*noAbs && t == evdev.EV_ABS) || !whereMayMatchType(t) {
+			clearBit(types, int(t))
+			masked = true
+		}
+	}
+	if masked {
+		ret[evdev.EV_SYN] = types
+	}
+	if !*showScan {
+		codes := allBits(evdev.MSC_MAX)
+		clearBit(codes, evdev.MSC_SCAN)
+		ret[evdev.EV_MSC] = codes
+	}
+
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// whereMayMatchType returns whether --where can match events of type t.
+func whereMayMatchType(t evdev.EvType) bool {
+	if whereExpr == nil {
+		return true
+	}
+	if *simple && (t == evdev.EV_KEY || t == evdev.EV_LED) {
+		return true // Simple mode tracks the modifiers and the LEDs from the events.
+	}
+	return whereExpr.MayMatch(func(name string) (evutil.Value, bool) {
+		switch name {
+		case "kind":
+			return evutil.StrValue("evdev"), true
+		case "type":
+			return evutil.NamedValue(int64(t), evdev.TypeName(t)), true
+		}
+		return evutil.Value{}, false
+	})
+}
+
+// applyKernelMasks sets the masks from kernelMasks on fd. It returns an error if the kernel
+// doesn't support EVIOCSMASK, in which case all the events will still be delivered.
+func applyKernelMasks(fd int) error {
+	for t, codes := range kernelMasks() {
+		m := inputMask{
+			Type:      uint32(t),
+			CodesSize: uint32(len(codes)),
+			CodesPtr:  uint64(uintptr(unsafe.Pointer(&codes[0]))),
+		}
+		err := doRawIoctl(uintptr(fd), evioCSMask, unsafe.Pointer(&m))
+		runtime.KeepAlive(codes)
+		if err != nil {
+			return fmt.Errorf("cannot set event mask for %s: %w", evdev.TypeName(t), err)
+		}
+	}
+	return nil
+}
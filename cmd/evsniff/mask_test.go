@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestKernelMasks(t *testing.T) {
+	defer resetFlags()
+
+	resetFlags()
+	*showScan = true
+	if m := kernelMasks(); m != nil {
+		t.Errorf("kernelMasks() = %v, want nil", m)
+	}
+
+	resetFlags()
+	*noAbs = true
+	m := kernelMasks()
+	types := m[evdev.EV_SYN]
+	if bitIsSet(types, evdev.EV_ABS) || !bitIsSet(types, evdev.EV_REL) || !bitIsSet(types, evdev.EV_SYN) {
+		t.Errorf("type mask = %08b", types)
+	}
+	if want := []byte{0xef}; !bytes.Equal(m[evdev.EV_MSC], want) {
+		t.Errorf("EV_MSC mask = %08b, want %08b", m[evdev.EV_MSC], want)
+	}
+
+	// Only the event types that --where can match.
+	resetFlags()
+	defer func() { whereExpr = nil }()
+	allTypes := func(except ...evdev.EvType) []evdev.EvType {
+		var ret []evdev.EvType
+		for typ := evdev.EvType(0); typ <= evdev.EV_MAX; typ++ {
+			if !slices.Contains(except, typ) {
+				ret = append(ret, typ)
+			}
+		}
+		return ret
+	}
+	for expr, want := range map[string][]evdev.EvType{
+		`type == EV_KEY && value == 1`:                                  {evdev.EV_SYN, evdev.EV_KEY},
+		`kind == midi || type == EV_REL || type == EV_ABS && value > 0`: {evdev.EV_SYN, evdev.EV_REL, evdev.EV_ABS},
+		`type != EV_ABS && type != EV_MSC`:                              allTypes(evdev.EV_ABS, evdev.EV_MSC),
+		`!(type == EV_KEY)`:                                             allTypes(evdev.EV_KEY),
+		`value == 1`:                                                    nil,
+	} {
+		var err error
+		if whereExpr, err = parseWhere(expr); err != nil {
+			t.Fatal(err)
+		}
+		types := kernelMasks()[evdev.EV_SYN]
+		if want == nil {
+			if types != nil {
+				t.Errorf("%s: type mask = %08b, want none", expr, types)
+			}
+			continue
+		}
+		for _, typ := range allTypes() {
+			if got := types != nil && bitIsSet(types, int(typ)); got != slices.Contains(want, typ) {
+				t.Errorf("%s: %s in the type mask = %v", expr, evdev.TypeName(typ), got)
+			}
+		}
+	}
+	whereExpr, _ = parseWhere(`type == EV_ABS`)
+	*simple = true
+	if types := kernelMasks()[evdev.EV_SYN]; !bitIsSet(types, evdev.EV_KEY) || !bitIsSet(types, evdev.EV_LED) || bitIsSet(types, evdev.EV_REL) {
+		t.Errorf("type mask in simple mode = %08b", types)
+	}
+
+	// Recording keeps all the events.
+	*recordFile = "out.evemu"
+	if m := kernelMasks(); m != nil {
+		t.Errorf("kernelMasks() while recording = %v, want nil", m)
+	}
+}
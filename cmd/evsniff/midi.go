@@ -2,11 +2,16 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/holoplot/go-evdev"
 	"github.com/omakoto/evsniff-go/evutil"
 )
 
@@ -17,10 +22,21 @@ type MidiDevice struct {
 	device  int
 	vendor  uint16
 	product uint16
-	file    *os.File
+	bus     uint16
+	phys    string
+	uniq    string
+	port    string   // The rawmidi device name, e.g. "UM-ONE MIDI 1".
+	subs    []string // The subdevice names, e.g. "DIN", "USB" and "Editor".
+	sub     int      // The subdevice selected by its name; -1 for any.
+	fd      int      // Raw non-blocking file descriptor; -1 if not open.
 }
 
-var _ evutil.Device = (*MidiDevice)(nil)
+var (
+	_ evutil.IDDevice   = (*MidiDevice)(nil)
+	_ evutil.PhysDevice = (*MidiDevice)(nil)
+	_ evutil.UniqDevice = (*MidiDevice)(nil)
+	_ evutil.PortDevice = (*MidiDevice)(nil)
+)
 
 func (m *MidiDevice) Path() string {
 	return m.path
@@ -30,10 +46,33 @@ func (m *MidiDevice) Name() (string, error) {
 	return m.name, nil
 }
 
-func listMidiDevices(sel evutil.Selector) []*MidiDevice {
+func (m *MidiDevice) InputID() (evdev.InputID, error) {
+	return evdev.InputID{BusType: m.bus, Vendor: m.vendor, Product: m.product}, nil
+}
+
+func (m *MidiDevice) PhysicalLocation() (string, error) {
+	return m.phys, nil
+}
+
+func (m *MidiDevice) UniqueID() (string, error) {
+	return m.uniq, nil
+}
+
+func (m *MidiDevice) PortNames() []string {
+	ret := make([]string, 0, len(m.subs)+1)
+	for _, n := range append([]string{m.port}, m.subs...) {
+		if n != "" && !slices.Contains(ret, n) {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+// findMidiDevices returns the rawmidi devices matching sel, without opening them.
+func findMidiDevices(sel evutil.Selector) []*MidiDevice {
 	ret := make([]*MidiDevice, 0)
 
-	files, err := filepath.Glob("/dev/snd/midiC*D*")
+	files, err := filepath.Glob(filepath.Join(sndDevPath, "midiC*D*"))
 	if err != nil {
 		return ret
 	}
@@ -46,32 +85,50 @@ func listMidiDevices(sel evutil.Selector) []*MidiDevice {
 			continue
 		}
 
-		name := cardNames[card]
-		if name == "" {
-			name = fmt.Sprintf("MIDI Card %d Device %d", card, device)
-		}
+		d := newMidiDevice(path, card, device, cardNames)
 
-		vendor, product := getMidiUsbIds(card, device)
-
-		d := &MidiDevice{
-			path:    path,
-			name:    name,
-			card:    card,
-			device:  device,
-			vendor:  vendor,
-			product: product,
+		if evutil.Matches(sel, d) {
+			d.selectSubdevice(sel)
+			ret = append(ret, d)
 		}
+	}
+	return ret
+}
 
-		if !evutil.Matches(sel, d) {
-			continue
+// selectSubdevice sets the subdevice to open, when sel selects d only by the name of one of its
+// subdevices, e.g. "Editor". Otherwise, opening d takes the first free subdevice.
+func (m *MidiDevice) selectSubdevice(sel evutil.Selector) {
+	m.sub = -1
+	if len(m.subs) < 2 {
+		return
+	}
+	c := *m
+	c.subs = nil
+	if evutil.Matches(sel, &c) {
+		return
+	}
+	for i, name := range m.subs {
+		c.subs = []string{name}
+		if evutil.Matches(sel, &c) {
+			m.sub = i
+			return
 		}
+	}
+}
 
-		f, err := os.Open(path)
+func listMidiDevices(sel evutil.Selector) []*MidiDevice {
+	ret := make([]*MidiDevice, 0)
+
+	for _, d := range findMidiDevices(sel) {
+		rememberDevice(d)
+		labelDevice(d)
+
+		fd, err := openMidiDevice(d.path, d.sub)
 		if err != nil {
-			fmt.Printf("Error opening MIDI device %s: %s\n", path, err)
+			fmt.Printf("Error opening MIDI device %s: %s\n", d.path, err)
 			continue
 		}
-		d.file = f
+		d.fd = fd
 
 		dumpMidiDevice(d, "    ")
 
@@ -81,6 +138,19 @@ func listMidiDevices(sel evutil.Selector) []*MidiDevice {
 	return ret
 }
 
+// openMidiDevice opens a rawmidi device node for non-blocking reads, from the subdevice sub,
+// or any if it's -1.
+func openMidiDevice(path string, sub int) (int, error) {
+	return openRawmidi(path, sub, syscall.O_RDONLY|syscall.O_NONBLOCK)
+}
+
+func (m *MidiDevice) close() {
+	if m.fd >= 0 {
+		_ = syscall.Close(m.fd)
+		m.fd = -1
+	}
+}
+
 func parseMidiPath(path string) (card, device int, err error) {
 	base := filepath.Base(path)
 	_, err = fmt.Sscanf(base, "midiC%dD%d", &card, &device)
@@ -114,41 +184,112 @@ func getCardNames() map[int]string {
 	return names
 }
 
-func getMidiUsbIds(card, device int) (uint16, uint16) {
-	sysPath := fmt.Sprintf("/sys/class/sound/midiC%dD%d/device", card, device)
+var sysfsSoundPath = "/sys/class/sound"
+
+// midiUsbInfo is what sysfs knows about the USB device of a rawmidi device.
+type midiUsbInfo struct {
+	vendor  uint16
+	product uint16
+	bus     uint16
+	phys    string // In the format of input devices, e.g. "usb-0000:00:14.0-2/input1".
+	uniq    string // The USB serial number.
+}
+
+// getMidiUsbInfo walks up from the sound device to the USB device in sysfs.
+// It returns a zero midiUsbInfo for non-USB devices.
+func getMidiUsbInfo(card, device int) midiUsbInfo {
+	sysPath := filepath.Join(sysfsSoundPath, fmt.Sprintf("midiC%dD%d", card, device), "device")
 	absPath, err := filepath.EvalSymlinks(sysPath)
 	if err != nil {
-		return 0, 0
+		return midiUsbInfo{}
 	}
 
-	curr := absPath
-	for {
-		vendorFile := filepath.Join(curr, "idVendor")
-		productFile := filepath.Join(curr, "idProduct")
-
-		_, errV := os.Stat(vendorFile)
-		_, errP := os.Stat(productFile)
-		if errV == nil && errP == nil {
-			vBytes, _ := os.ReadFile(vendorFile)
-			pBytes, _ := os.ReadFile(productFile)
-
-			var v, p uint32
-			fmt.Sscanf(strings.TrimSpace(string(vBytes)), "%x", &v)
-			fmt.Sscanf(strings.TrimSpace(string(pBytes)), "%x", &p)
-			return uint16(v), uint16(p)
+	iface := ""
+	for curr := absPath; curr != "/" && curr != "."; curr = filepath.Dir(curr) {
+		if iface == "" {
+			if n := readSysfsString(curr, "bInterfaceNumber"); n != "" {
+				num, _ := strconv.ParseUint(n, 16, 8)
+				iface = fmt.Sprintf("/input%d", num)
+			}
 		}
-
-		parent := filepath.Dir(curr)
-		if parent == curr || parent == "/" || parent == "." {
-			break
+		if _, err := os.Stat(filepath.Join(curr, "idVendor")); err != nil {
+			continue
+		}
+		info := midiUsbInfo{
+			vendor:  readSysfsHex(curr, "idVendor"),
+			product: readSysfsHex(curr, "idProduct"),
+			bus:     evdev.BUS_USB,
+			uniq:    readSysfsString(curr, "serial"),
+		}
+		// The host controller is the parent of the root hub, "usbN".
+		hc := ""
+		for p := curr; p != "/" && p != "."; p = filepath.Dir(p) {
+			if strings.HasPrefix(filepath.Base(p), "usb") {
+				hc = filepath.Base(filepath.Dir(p))
+				break
+			}
 		}
-		curr = parent
+		if devpath := readSysfsString(curr, "devpath"); hc != "" && devpath != "" {
+			info.phys = "usb-" + hc + "-" + devpath + iface
+		}
+		return info
+	}
+	return midiUsbInfo{}
+}
+
+// newMidiDevice returns a MidiDevice for a /dev/snd/midiCxDy node, which isn't opened yet.
+// The name is the card name, followed by the port name, so the ports of a multi-port interface
+// can be told apart.
+func newMidiDevice(path string, card, device int, cardNames map[int]string) *MidiDevice {
+	name := cardNames[card]
+	ports, _ := getMidiPortsFn(card, device)
+	switch {
+	case ports.name == "" || ports.name == name:
+	case name == "":
+		name = ports.name
+	default:
+		name += ": " + ports.name
+	}
+	if name == "" {
+		name = fmt.Sprintf("MIDI Card %d Device %d", card, device)
+	}
+	info := getMidiUsbInfo(card, device)
+	return &MidiDevice{
+		path:    path,
+		name:    name,
+		card:    card,
+		device:  device,
+		vendor:  info.vendor,
+		product: info.product,
+		bus:     info.bus,
+		phys:    info.phys,
+		uniq:    info.uniq,
+		port:    ports.name,
+		subs:    ports.subdevices,
+		sub:     -1,
+		fd:      -1,
 	}
-	return 0, 0
 }
 
 func dumpMidiDevice(d *MidiDevice, prefix string) {
+	if *jsonOutput {
+		printJsonDevice(d.path, d.name, d.vendor, d.product)
+		return
+	}
 	fmt.Printf("%-20s [v%04X p%04X]:\t%s\n", d.path, d.vendor, d.product, d.name)
+	// Show the subdevices if there's more than one port, or its name says more than the device name.
+	if len(d.subs) > 1 || (len(d.subs) == 1 && d.subs[0] != "" && d.subs[0] != d.port) {
+		for i, sub := range d.subs {
+			selected := ""
+			if i == d.sub {
+				selected = " (selected)"
+			}
+			fmt.Printf("%sSubdevice %d: %s%s\n", prefix, i, sub, selected)
+		}
+	}
+	if *verbose && d.bus != 0 {
+		dumpAttributes(d.bus, d.phys, d.uniq, prefix)
+	}
 }
 
 type MidiEvent struct {
@@ -185,6 +326,21 @@ func (p *MidiParser) ParseByte(b byte, ts time.Time) {
 		return
 	}
 
+	if b == 0xF7 && p.expectedLen == -1 {
+		p.buffer = append(p.buffer, b)
+		sysex := make([]byte, len(p.buffer))
+		copy(sysex, p.buffer)
+		p.onEvent(MidiEvent{
+			Timestamp: ts,
+			Status:    0xF0,
+			SysEx:     sysex,
+			Type:      "SysEx",
+		})
+		p.buffer = p.buffer[:0]
+		p.expectedLen = 0
+		return
+	}
+
 	if b >= 0x80 {
 		p.runningStatus = b
 		p.buffer = p.buffer[:0]
@@ -213,18 +369,6 @@ func (p *MidiParser) ParseByte(b byte, ts time.Time) {
 
 	if p.expectedLen == -1 {
 		p.buffer = append(p.buffer, b)
-		if b == 0xF7 {
-			sysex := make([]byte, len(p.buffer))
-			copy(sysex, p.buffer)
-			p.onEvent(MidiEvent{
-				Timestamp: ts,
-				Status:    0xF0,
-				SysEx:     sysex,
-				Type:      "SysEx",
-			})
-			p.buffer = p.buffer[:0]
-			p.expectedLen = 0
-		}
 		return
 	}
 
@@ -539,33 +683,122 @@ func ccName(cc byte) string {
 	return "Unknown"
 }
 
-func testMidiDevice(d *MidiDevice, col colorizer) {
-	path := d.Path()
-	name := d.name
+// midiSource reads MIDI bytes from a rawmidi device on the reactor.
+type midiSource struct {
+	d      *MidiDevice
+	lc     *deviceLifecycle
+	parser *MidiParser
+	buf    []byte
+
+	// framed is set when the kernel timestamps the input, see enableMidiTimestamps.
+	framed bool
+}
 
+var _ reactorSource = (*midiSource)(nil)
+
+// startMidiDevice starts reading from d on the reactor.
+func startMidiDevice(r *reactor, d *MidiDevice, col colorizer) {
 	if *verbose {
-		fmt.Printf("Waiting for MIDI input (%s)...\n", name)
+		fmt.Printf("Waiting for MIDI input (%s)...\n", d.name)
 	}
 
-	parser := NewMidiParser(func(ev MidiEvent) {
-		printMidiEvent(ev, d, col)
-	})
-
-	buf := make([]byte, 256)
-	for {
-		n, err := d.file.Read(buf)
+	path, sub := d.path, d.sub
+	lc := openingDevice(r, col, path, func() error {
+		card, device, err := parseMidiPath(path)
 		if err != nil {
-			fmt.Printf("Error reading from MIDI device %s: %v\n", path, err)
-			break
+			return err
 		}
-		ts := time.Now()
-		for i := 0; i < n; i++ {
-			parser.ParseByte(buf[i], ts)
+		fd, err := openMidiDevice(path, sub)
+		if err != nil {
+			return err
 		}
+		d := newMidiDevice(path, card, device, getCardNames())
+		d.sub = sub
+		d.fd = fd
+		startMidiDevice(r, d, col)
+		return nil
+	})
+	var track *smfTrack
+	if midiRec != nil {
+		track = midiRec.track(d)
+	}
+	s := &midiSource{
+		d:  d,
+		lc: lc,
+		parser: NewMidiParser(func(ev MidiEvent) {
+			lc.events++
+			if track != nil {
+				track.record(ev)
+			}
+			printMidiEvent(ev, d, col)
+		}),
+		buf: make([]byte, 256),
+	}
+	if err := enableMidiTimestamps(d.fd); err == nil {
+		s.framed = true
+		s.buf = make([]byte, rawmidiBufferSize)
+	} else if *verbose {
+		fmt.Printf("No kernel timestamps for %s, using the time of reading: %v\n", d.path, err)
 	}
+	if err := r.add(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to watch %s: %v\n", d.path, err)
+		s.close()
+		lc.failed(err)
+		return
+	}
+	lc.activate(d.name)
+}
+
+func (s *midiSource) fd() int {
+	return s.d.fd
+}
+
+func (s *midiSource) onReadable() error {
+	n, err := syscall.Read(s.d.fd, s.buf)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil
+	}
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		s.lc.disconnected(err)
+		return err
+	}
+	if s.framed {
+		decodeMidiFrames(s.buf[:n], s.parser.ParseByte)
+		return nil
+	}
+	ts := time.Now()
+	for i := 0; i < n; i++ {
+		s.parser.ParseByte(s.buf[i], ts)
+	}
+	return nil
+}
+
+func (s *midiSource) close() {
+	s.d.close()
+}
+
+// printMidiHeader prints the device header if the previous event was from another device, or
+// a while ago.
+func printMidiHeader(d *MidiDevice, col colorizer) {
+	now := time.Now()
+	if now.Sub(lastTime) > time.Second*3 || lastPath != headerKey(d.path) {
+		printDeviceHeader(col, d.path, evdev.InputID{Vendor: d.vendor, Product: d.product}, d.name)
+	}
+	lastTime = now
+	lastPath = headerKey(d.path)
 }
 
 func printMidiEvent(ev MidiEvent, d *MidiDevice, col colorizer) {
+	if !matchesMidiWhere(ev, d) {
+		return
+	}
+	if *jsonOutput {
+		printJsonMidiEvent(ev, d)
+		return
+	}
 	if *simple {
 		switch ev.Type {
 		case "NoteOn":
@@ -587,25 +820,7 @@ func printMidiEvent(ev MidiEvent, d *MidiDevice, col colorizer) {
 
 	ts := fmt.Sprintf("[%s%d.%06d%s]", col.time(), ev.Timestamp.Unix(), ev.Timestamp.Nanosecond()/1000, col.reset())
 
-	now := time.Now()
-	mu.Lock()
-	defer mu.Unlock()
-	if now.Sub(lastTime) > time.Second*3 || lastPath != d.path {
-		fmt.Printf("%s# From device [%sv%04X p%04X%s]: %s%s%s (%s)%s\n",
-			col.deviceLine(),
-			col.deviceId(),
-			d.vendor,
-			d.product,
-			col.deviceLine(),
-			col.deviceName(),
-			d.name,
-			col.deviceLine(),
-			d.path,
-			col.reset(),
-		)
-	}
-	lastTime = now
-	lastPath = d.path
+	printMidiHeader(d, col)
 
 	switch ev.Type {
 	case "NoteOn":
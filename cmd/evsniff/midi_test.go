@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+// noMidiPorts is getMidiPortsFn for devices without a control device.
+func noMidiPorts(card, device int) (midiPorts, error) {
+	return midiPorts{}, errors.New("no control device")
+}
+
+func TestMidiUsbInfo(t *testing.T) {
+	orig, origPorts := sysfsSoundPath, getMidiPortsFn
+	defer func() { sysfsSoundPath, getMidiPortsFn = orig, origPorts }()
+	getMidiPortsFn = noMidiPorts
+	root := t.TempDir()
+	sysfsSoundPath = filepath.Join(root, "class", "sound")
+
+	usbDev := filepath.Join(root, "devices", "pci0000:00", "0000:00:14.0", "usb1", "1-2")
+	iface := filepath.Join(usbDev, "1-2:1.1")
+	writeSysfsFiles(t, usbDev, map[string]string{
+		"idVendor":  "2467",
+		"idProduct": "2031",
+		"devpath":   "2",
+		"serial":    "DMK25-0001",
+	})
+	writeSysfsFiles(t, iface, map[string]string{
+		"bInterfaceNumber":     "01",
+		"sound/card1/midiC1D0": "",
+	})
+	midiDir := filepath.Join(sysfsSoundPath, "midiC1D0")
+	if err := os.MkdirAll(midiDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(iface, filepath.Join(midiDir, "device")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newMidiDevice("/dev/snd/midiC1D0", 1, 0, map[int]string{1: "DONNER DMK25Pro"})
+	want := &MidiDevice{
+		path: "/dev/snd/midiC1D0", name: "DONNER DMK25Pro", card: 1,
+		vendor: 0x2467, product: 0x2031, bus: evdev.BUS_USB,
+		phys: "usb-0000:00:14.0-2/input1", uniq: "DMK25-0001", sub: -1, fd: -1,
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("got %+v, want %+v", *d, *want)
+	}
+
+	for _, arg := range []string{"vendor=2467", "bus=usb", "phys=usb-*-2/*", "uniq=DMK25-*"} {
+		s, err := parseFilter(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !evutil.Matches(evutil.NewCombinedSelector().Add(s), d) {
+			t.Errorf("%s doesn't match", arg)
+		}
+	}
+
+	// Not a USB device.
+	d = newMidiDevice("/dev/snd/midiC2D0", 2, 0, nil)
+	if d.name != "MIDI Card 2 Device 0" || d.bus != 0 || d.phys != "" {
+		t.Errorf("got %+v", *d)
+	}
+}
+
+func TestMidiPortNames(t *testing.T) {
+	if size := unsafe.Sizeof(rawmidiInfo{}); size != 268 {
+		t.Errorf("sizeof(struct snd_rawmidi_info) = %d, want 268", size)
+	}
+	orig, origPorts := sysfsSoundPath, getMidiPortsFn
+	defer func() { sysfsSoundPath, getMidiPortsFn = orig, origPorts }()
+	sysfsSoundPath = t.TempDir()
+	resetFlags()
+
+	getMidiPortsFn = func(card, device int) (midiPorts, error) {
+		switch device {
+		case 0:
+			return midiPorts{"Fantom MIDI", []string{"DIN", "USB", "Editor"}}, nil
+		case 1:
+			return midiPorts{"Fantom DAW", []string{"Fantom DAW"}}, nil
+		}
+		return midiPorts{"Fantom", []string{"Fantom"}}, nil
+	}
+	cardNames := map[int]string{1: "Fantom"}
+	din := newMidiDevice("/dev/snd/midiC1D0", 1, 0, cardNames)
+	daw := newMidiDevice("/dev/snd/midiC1D1", 1, 1, cardNames)
+	plain := newMidiDevice("/dev/snd/midiC1D2", 1, 2, cardNames)
+	unnamed := newMidiDevice("/dev/snd/midiC2D1", 2, 1, nil)
+
+	for _, tt := range []struct {
+		d     *MidiDevice
+		name  string
+		ports []string
+	}{
+		{din, "Fantom: Fantom MIDI", []string{"Fantom MIDI", "DIN", "USB", "Editor"}},
+		{daw, "Fantom: Fantom DAW", []string{"Fantom DAW"}},
+		{plain, "Fantom", []string{"Fantom"}},
+		{unnamed, "Fantom DAW", []string{"Fantom DAW"}},
+	} {
+		if tt.d.name != tt.name || !slices.Equal(tt.d.PortNames(), tt.ports) {
+			t.Errorf("%s: name = %q, ports = %q, want %q, %q", tt.d.path, tt.d.name, tt.d.PortNames(), tt.name, tt.ports)
+		}
+	}
+
+	sel := evutil.NewCombinedSelector().Add(evutil.NewReSelector("editor"))
+	if !evutil.Matches(sel, din) || evutil.Matches(sel, daw) {
+		t.Errorf("editor matches the wrong port")
+	}
+
+	for _, tt := range []struct {
+		filters []string
+		want    int
+	}{
+		{[]string{"editor"}, 2},
+		{[]string{"usb|editor"}, 1},
+		{[]string{"din", "fantom midi"}, -1},
+		{[]string{"/dev/snd/midiC1D0"}, -1},
+	} {
+		sel := evutil.NewCombinedSelector()
+		for _, f := range tt.filters {
+			s, err := parseFilter(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sel.Add(s)
+		}
+		din.selectSubdevice(sel)
+		if din.sub != tt.want {
+			t.Errorf("%q: got subdevice %d, want %d", tt.filters, din.sub, tt.want)
+		}
+	}
+
+	din.selectSubdevice(sel)
+	stdout, _, _ := captureOutput(func() {
+		dumpMidiDevice(din, "    ")
+		dumpMidiDevice(daw, "    ")
+	})
+	want := []string{
+		"/dev/snd/midiC1D0    [v0000 p0000]:\tFantom: Fantom MIDI",
+		"    Subdevice 0: DIN",
+		"    Subdevice 1: USB",
+		"    Subdevice 2: Editor (selected)",
+		"/dev/snd/midiC1D1    [v0000 p0000]:\tFantom: Fantom DAW",
+	}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOpenRawmidiSubdevice(t *testing.T) {
+	orig := sndDevPath
+	defer func() { sndDevPath = orig }()
+	sndDevPath = t.TempDir()
+	path := filepath.Join(sndDevPath, "midiC1D0")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := openMidiDevice(path, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(fd)
+
+	// A subdevice is requested on the control device, which doesn't exist.
+	if _, err := openMidiDevice(path, 2); !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), "controlC1") {
+		t.Errorf("got %v", err)
+	}
+	// Not a control device.
+	if err := os.WriteFile(filepath.Join(sndDevPath, "controlC1"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openMidiDevice(path, 2); !errors.Is(err, syscall.ENOTTY) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func midiFrame(sec uint64, nsec uint32, data ...byte) []byte {
+	b := make([]byte, rawmidiFrameSize)
+	b[1] = byte(len(data))
+	binary.NativeEndian.PutUint32(b[4:], nsec)
+	binary.NativeEndian.PutUint64(b[8:], sec)
+	copy(b[16:], data)
+	return b
+}
+
+func TestDecodeMidiFrames(t *testing.T) {
+	if unsafe.Sizeof(uint(0)) == 8 {
+		if size := unsafe.Sizeof(rawmidiParams{}); size != 48 {
+			t.Errorf("sizeof(struct snd_rawmidi_params) = %d, want 48", size)
+		}
+	}
+
+	var buf []byte
+	buf = append(buf, midiFrame(1712345678, 123456789, 0x90, 60)...)
+	buf = append(buf, midiFrame(1712345678, 123756789, 100, 0x80, 60, 0)...)
+	unknown := midiFrame(1712345679, 0, 0xfe)
+	unknown[0] = 1
+	buf = append(buf, unknown...)
+	buf = append(buf, 0x90) // A partial frame.
+
+	var events []MidiEvent
+	p := NewMidiParser(func(ev MidiEvent) {
+		events = append(events, ev)
+	})
+	decodeMidiFrames(buf, p.ParseByte)
+
+	want := []struct {
+		typ string
+		ts  time.Time
+	}{
+		{"NoteOn", time.Unix(1712345678, 123756789)}, // Completed by the second frame.
+		{"NoteOff", time.Unix(1712345678, 123756789)},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events: %+v", len(events), events)
+	}
+	for i, w := range want {
+		if events[i].Type != w.typ || !events[i].Timestamp.Equal(w.ts) {
+			t.Errorf("event %d = %s at %v, want %s at %v", i, events[i].Type, events[i].Timestamp, w.typ, w.ts)
+		}
+	}
+}
+
+func TestParseSysEx(t *testing.T) {
+	var events []MidiEvent
+	p := NewMidiParser(func(ev MidiEvent) {
+		events = append(events, ev)
+	})
+	for _, b := range []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7, 0x90, 60, 100} {
+		p.ParseByte(b, time.Unix(1712345678, 0))
+	}
+	if len(events) != 2 || events[0].Type != "SysEx" || events[1].Type != "NoteOn" {
+		t.Fatalf("got %+v", events)
+	}
+	if want := []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}; !slices.Equal(events[0].SysEx, want) {
+		t.Errorf("SysEx = % x, want % x", events[0].SysEx, want)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+// --play-midi: render a Standard MIDI File with the same decoder and printer as live devices.
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+// smfTimedEvent is an event of a file on the merged timeline of all the tracks.
+type smfTimedEvent struct {
+	track   int
+	elapsed time.Duration // Since the start of the file.
+	smfFileEvent
+}
+
+// smfTimeline merges the tracks and converts ticks to durations, following the tempo events
+// of all the tracks (usually the first one). Events at the same tick keep the track order.
+func smfTimeline(f *smfFile) []smfTimedEvent {
+	var events []smfTimedEvent
+	for i, t := range f.tracks {
+		for _, e := range t {
+			events = append(events, smfTimedEvent{track: i, smfFileEvent: e})
+		}
+	}
+	slices.SortStableFunc(events, func(a, b smfTimedEvent) int {
+		return cmp.Compare(a.tick, b.tick)
+	})
+
+	// Nanoseconds per tick is tempo * 1000 / division, or with SMPTE timing, where the upper
+	// byte is the negative frame rate, 1e9 / (fps * ticks per frame).
+	tempo := uint64(smfTempo)
+	var elapsed time.Duration
+	var lastTick uint64
+	for i := range events {
+		e := &events[i]
+		delta := e.tick - lastTick
+		if f.division&0x8000 != 0 {
+			fps := uint64(-int8(f.division >> 8))
+			elapsed += time.Duration(delta * 1e9 / (fps * uint64(f.division&0xFF)))
+		} else {
+			elapsed += time.Duration(delta * tempo * 1000 / uint64(f.division))
+		}
+		lastTick = e.tick
+		e.elapsed = elapsed
+		if e.isMeta && e.metaType == 0x51 && len(e.data) == 3 {
+			tempo = uint64(e.data[0])<<16 | uint64(e.data[1])<<8 | uint64(e.data[2])
+		}
+	}
+	return events
+}
+
+// smfTrackName returns the name of a track from its Track Name meta event.
+func smfTrackName(events []smfFileEvent, i int) string {
+	for _, e := range events {
+		if e.isMeta && e.metaType == 0x03 {
+			return string(e.data)
+		}
+	}
+	return fmt.Sprintf("Track %d", i)
+}
+
+// playMidiFile prints the events of the selected tracks of a file. Each track is a device
+// named after the track, with the path FILE#N.
+func playMidiFile(file string, col colorizer, sel evutil.Selector) int {
+	in, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", file, err)
+		return 2
+	}
+	f, err := readSmf(in)
+	in.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
+		return 2
+	}
+
+	devices := make([]*MidiDevice, len(f.tracks))
+	parsers := make([]*MidiParser, len(f.tracks))
+	for i, t := range f.tracks {
+		d := &MidiDevice{path: fmt.Sprintf("%s#%d", file, i), name: smfTrackName(t, i), card: -1, device: i, fd: -1}
+		if !evutil.Matches(sel, d) {
+			continue
+		}
+		labelDevice(d)
+		dumpMidiDevice(d, "    ")
+		devices[i] = d
+		parsers[i] = NewMidiParser(func(ev MidiEvent) {
+			printMidiEvent(ev, d, col)
+		})
+	}
+	if !slices.ContainsFunc(devices, func(d *MidiDevice) bool { return d != nil }) {
+		fmt.Println("No devices selected.")
+		return 1
+	}
+	if *infoOnly {
+		return 0
+	}
+
+	start := time.Now()
+	for _, e := range smfTimeline(f) {
+		d := devices[e.track]
+		if d == nil {
+			continue
+		}
+		if *midiRealtime {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(e.elapsed) / *replaySpeed))))
+		}
+		// Timestamps are the time since the start of the file.
+		ts := time.Unix(0, int64(e.elapsed))
+		if e.isMeta {
+			printMidiMeta(e.metaType, e.data, ts, d, col)
+			continue
+		}
+		for _, b := range e.data {
+			parsers[e.track].ParseByte(b, ts)
+		}
+	}
+	return 0
+}
+
+// smfMetaTypes are the names of the meta events, as in the JSON output.
+var smfMetaTypes = map[byte]string{
+	0x00: "SequenceNumber",
+	0x01: "Text",
+	0x02: "Copyright",
+	0x03: "TrackName",
+	0x04: "InstrumentName",
+	0x05: "Lyric",
+	0x06: "Marker",
+	0x07: "CuePoint",
+	0x08: "ProgramName",
+	0x09: "DeviceName",
+	0x20: "ChannelPrefix",
+	0x21: "Port",
+	0x2F: "EndOfTrack",
+	0x51: "Tempo",
+	0x54: "SMPTEOffset",
+	0x58: "TimeSignature",
+	0x59: "KeySignature",
+	0x7F: "SequencerSpecific",
+}
+
+var (
+	majorKeys = []string{"Cb", "Gb", "Db", "Ab", "Eb", "Bb", "F", "C", "G", "D", "A", "E", "B", "F#", "C#"}
+	minorKeys = []string{"Ab", "Eb", "Bb", "F", "C", "G", "D", "A", "E", "B", "F#", "C#", "G#", "D#", "A#"}
+)
+
+// describeMeta returns the type and a description of a meta event.
+func describeMeta(typ byte, data []byte) (string, string) {
+	name, ok := smfMetaTypes[typ]
+	if !ok {
+		name = fmt.Sprintf("Meta0x%02X", typ)
+	}
+	switch {
+	case typ >= 0x01 && typ <= 0x0F:
+		return name, fmt.Sprintf("%q", strings.ToValidUTF8(string(data), "?"))
+	case typ == 0x00 && len(data) == 2:
+		return name, fmt.Sprintf("%d", int(data[0])<<8|int(data[1]))
+	case (typ == 0x20 || typ == 0x21) && len(data) == 1:
+		if typ == 0x20 {
+			return name, fmt.Sprintf("Ch %d", data[0]+1)
+		}
+		return name, fmt.Sprintf("%d", data[0])
+	case typ == 0x2F:
+		return name, ""
+	case typ == 0x51 && len(data) == 3:
+		us := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		if us == 0 {
+			break
+		}
+		return name, fmt.Sprintf("%.2f BPM (%d us per quarter note)", 60e6/float64(us), us)
+	case typ == 0x54 && len(data) == 5:
+		return name, fmt.Sprintf("%02d:%02d:%02d:%02d.%02d", data[0]&0x1F, data[1], data[2], data[3], data[4])
+	case typ == 0x58 && len(data) == 4 && data[1] < 8:
+		return name, fmt.Sprintf("%d/%d, %d MIDI clocks per click, %d 32nd notes per quarter note",
+			data[0], 1<<data[1], data[2], data[3])
+	case typ == 0x59 && len(data) == 2 && int8(data[0]) >= -7 && int8(data[0]) <= 7:
+		if data[1] == 1 {
+			return name, minorKeys[int8(data[0])+7] + " minor"
+		}
+		return name, majorKeys[int8(data[0])+7] + " major"
+	}
+	return name, fmt.Sprintf("% x", data)
+}
+
+// printMidiMeta prints a meta event of a file. End of Track isn't printed.
+func printMidiMeta(typ byte, data []byte, ts time.Time, d *MidiDevice, col colorizer) {
+	if typ == 0x2F || *simple || whereExpr != nil {
+		return
+	}
+	name, desc := describeMeta(typ, data)
+	if *jsonOutput {
+		printJsonMidiMeta(ts, d, name, desc)
+		return
+	}
+	printMidiHeader(d, col)
+	fmt.Printf("[%s%d.%06d%s] %sMeta: %s - %s%s\n",
+		col.time(), ts.Unix(), ts.Nanosecond()/1000, col.reset(), col.midiOther(), name, desc, col.reset())
+}
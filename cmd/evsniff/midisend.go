@@ -0,0 +1,366 @@
+package main
+
+// The midi-send subcommand: write MIDI messages to rawmidi devices, e.g. to light the pads of a
+// controller, and optionally watch the replies.
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/omakoto/evsniff-go/evutil"
+
+	"github.com/omakoto/go-common/src/common"
+	"github.com/pborman/getopt/v2"
+)
+
+const midiSendMessages = `  MESSAGE is a sequence of:
+    note-on CH NOTE [VELOCITY]   Note On; VELOCITY defaults to 100
+    note-off CH NOTE [VELOCITY]  Note Off; VELOCITY defaults to 0
+    cc CH CONTROLLER VALUE       Control Change
+    pc CH PROGRAM                Program Change
+    bend CH VALUE                Pitch Bend, 0-16383 (8192 = center)
+    hex BYTES                    Raw bytes, e.g. "f0 7e 7f 06 01 f7" or b0077f
+    sysex FILE                   The SysEx messages in a .syx file
+
+  CH is 1-16. NOTE is 0-127 or a name such as C4 (60), F#2 or Bb3. Numbers can be hex with 0x.
+`
+
+// midiSendMain runs "evsniff midi-send". args[0] is "midi-send".
+func midiSendMain(args []string) int {
+	resetFlags()
+	set := getopt.New()
+	set.SetProgram("evsniff midi-send")
+	set.SetParameters("FILTER MESSAGE...")
+	help := set.BoolLong("help", 'h', "show this help message")
+	set.FlagLong(verbose, "verbose", 'v', "print the messages as they're sent")
+	set.FlagLong(forceColor, "color", 'c', "force colored output even when stdout is not a terminal")
+	set.FlagLong(noColor, "no-color", 0, "disable colored output")
+	set.FlagLong(jsonOutput, "json", 'j', "with --watch, print one JSON object per event")
+	watch := set.BoolLong("watch", 0, "also monitor the input of the devices, to see their replies")
+	timeout := set.DurationLong("timeout", 't', 0, "with --watch, quit after DURATION instead of on Ctrl-C", "DURATION")
+	dryRun := set.BoolLong("dry-run", 'n', "print the messages and the devices, without sending anything")
+	usage := func() {
+		set.PrintUsage(os.Stderr)
+		fmt.Fprintf(os.Stderr, "\n"+
+			"Send MIDI messages to the /dev/snd/midi* devices matching FILTER, which is the same as\n"+
+			"the FILTERs of evsniff; use quotes and & or | to combine filters.\n"+
+			"\n"+
+			midiSendMessages+
+			"\n"+
+			"  Examples:\n"+
+			"    evsniff midi-send donner note-on 10 36 127 note-off 10 36  hit a drum pad\n"+
+			"    evsniff midi-send --watch donner hex 'f0 7e 7f 06 01 f7'  ask for the identity and show the reply\n"+
+			"    evsniff midi-send /dev/snd/midiC1D0 cc 1 7 100 pc 1 5  set the volume and the program\n"+
+			"    evsniff midi-send -n synth sysex patch.syx  show what would be sent\n"+
+			"\n")
+	}
+	set.SetUsage(usage)
+	if err := set.Getopt(args, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		set.PrintUsage(os.Stderr)
+		return 2
+	}
+	if *help {
+		usage()
+		return 0
+	}
+	if set.NArgs() < 2 {
+		fmt.Fprintln(os.Stderr, "Error: FILTER and MESSAGE are required")
+		set.PrintUsage(os.Stderr)
+		return 2
+	}
+	if *timeout != 0 && !*watch {
+		fmt.Fprintln(os.Stderr, "Error: --timeout can only be used with --watch")
+		return 2
+	}
+
+	loadConfigAndAliases()
+	filter, err := parseFilter(set.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	warnConfigErr()
+	sel := evutil.NewCombinedSelector().Add(filter)
+	messages, err := parseMidiMessages(set.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	devs := findMidiDevices(sel)
+	if len(devs) == 0 {
+		fmt.Println("No devices selected.")
+		return 1
+	}
+	col := newColorizer()
+
+	if *dryRun {
+		for _, d := range devs {
+			for _, m := range messages {
+				fmt.Printf("Would send to %s (%s): % x\n", d.path, d.name, m)
+			}
+		}
+		return 0
+	}
+
+	// Start watching before sending, so no replies are missed.
+	var r *reactor
+	if *watch {
+		clear(deviceLabels)
+		clear(lifecycles)
+		r, err = newReactor()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create epoll instance: %v\n", err)
+			return 1
+		}
+		defer r.close()
+		for _, d := range devs {
+			fd, err := openMidiDevice(d.path, d.sub)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot watch %s: %v\n", d.path, err)
+				continue
+			}
+			labelDevice(d)
+			d.fd = fd
+			startMidiDevice(r, d, col)
+		}
+	}
+
+	ret := 0
+	for _, d := range devs {
+		if err := sendMidiMessages(d, messages); err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending to %s: %v\n", d.path, err)
+			ret = 1
+		}
+	}
+
+	if r != nil {
+		err = r.onSignal(func(sig os.Signal) {
+			r.stop()
+		}, os.Interrupt, syscall.SIGTERM)
+		common.Checkf(err, "Cannot handle signals")
+		if *timeout > 0 {
+			r.after(*timeout, r.stop)
+		}
+		r.run()
+	}
+	return ret
+}
+
+// sendMidiMessages writes the messages to a rawmidi device. Opening it for writing only takes
+// an output substream, so it can be monitored at the same time. It's the subdevice selected by
+// name, if any.
+func sendMidiMessages(d *MidiDevice, messages [][]byte) error {
+	fd, err := openRawmidi(d.path, d.sub, syscall.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), d.path)
+	for _, m := range messages {
+		if _, err := f.Write(m); err != nil {
+			f.Close()
+			return err
+		}
+		if *verbose {
+			fmt.Printf("Sent to %s (%s): % x\n", d.path, d.name, m)
+		}
+	}
+	return f.Close()
+}
+
+// parseMidiNumber parses a decimal, or hex with 0x, number in [0, max].
+func parseMidiNumber(s, what string, max int) (int, error) {
+	digits, base := s, 10
+	if rest, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		digits, base = rest, 16
+	}
+	v, err := strconv.ParseUint(digits, base, 16)
+	if err != nil || int(v) > max {
+		return 0, fmt.Errorf("invalid %s %q: must be 0-%d", what, s, max)
+	}
+	return int(v), nil
+}
+
+// parseMidiChannel parses a channel number, 1-16, to 0-15.
+func parseMidiChannel(s string) (byte, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 || v > 16 {
+		return 0, fmt.Errorf("invalid channel %q: must be 1-16", s)
+	}
+	return byte(v - 1), nil
+}
+
+// parseNote parses a note number, or a name in the format of noteName, with # or b.
+func parseNote(s string) (byte, error) {
+	if v, err := parseMidiNumber(s, "note", 127); err == nil {
+		return byte(v), nil
+	}
+	invalid := fmt.Errorf("invalid note %q: must be 0-127 or a name such as C4", s)
+	if s == "" {
+		return 0, invalid
+	}
+	semitone := strings.IndexByte("C D EF G A B", strings.ToUpper(s)[0])
+	if semitone < 0 || s[0] == ' ' {
+		return 0, invalid
+	}
+	rest := s[1:]
+	if r, ok := strings.CutPrefix(rest, "#"); ok {
+		semitone, rest = semitone+1, r
+	} else if r, ok := strings.CutPrefix(rest, "b"); ok {
+		semitone, rest = semitone-1, r
+	}
+	octave, err := strconv.Atoi(rest)
+	note := (octave+1)*12 + semitone
+	if err != nil || note < 0 || note > 127 {
+		return 0, invalid
+	}
+	return byte(note), nil
+}
+
+// parseSyx splits the contents of a .syx file into SysEx messages.
+func parseSyx(b []byte) ([][]byte, error) {
+	var ret [][]byte
+	for len(b) > 0 {
+		if b[0] != 0xF0 {
+			return nil, fmt.Errorf("expected F0, found %02X", b[0])
+		}
+		end := 1
+		for end < len(b) && b[end] < 0x80 {
+			end++
+		}
+		if end == len(b) || b[end] != 0xF7 {
+			return nil, errors.New("unterminated SysEx message")
+		}
+		ret = append(ret, b[:end+1])
+		b = b[end+1:]
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no SysEx messages")
+	}
+	return ret, nil
+}
+
+// parseMidiMessages parses the MESSAGE arguments of midi-send; see midiSendMessages.
+func parseMidiMessages(args []string) ([][]byte, error) {
+	var ret [][]byte
+	for len(args) > 0 {
+		cmd := args[0]
+		args = args[1:]
+
+		// next returns the next argument, and optional ones must be numbers.
+		next := func(optional bool) (string, bool) {
+			if len(args) == 0 || (optional && (args[0] == "" || args[0][0] < '0' || args[0][0] > '9')) {
+				return "", false
+			}
+			s := args[0]
+			args = args[1:]
+			return s, true
+		}
+		var params []string
+		need := func(names ...string) error {
+			for _, name := range names {
+				s, ok := next(false)
+				if !ok {
+					return fmt.Errorf("%s: missing %s", cmd, name)
+				}
+				params = append(params, s)
+			}
+			return nil
+		}
+
+		var err error
+		var ch, d1, d2 byte
+		var v int
+		switch cmd {
+		case "note-on", "note-off":
+			if err = need("CH", "NOTE"); err != nil {
+				return nil, err
+			}
+			if ch, err = parseMidiChannel(params[0]); err != nil {
+				return nil, err
+			}
+			if d1, err = parseNote(params[1]); err != nil {
+				return nil, err
+			}
+			status := byte(0x80)
+			if cmd == "note-on" {
+				status, d2 = 0x90, 100
+			}
+			if s, ok := next(true); ok {
+				if v, err = parseMidiNumber(s, "velocity", 127); err != nil {
+					return nil, err
+				}
+				d2 = byte(v)
+			}
+			ret = append(ret, []byte{status | ch, d1, d2})
+		case "cc":
+			if err = need("CH", "CONTROLLER", "VALUE"); err != nil {
+				return nil, err
+			}
+			if ch, err = parseMidiChannel(params[0]); err != nil {
+				return nil, err
+			}
+			if v, err = parseMidiNumber(params[1], "controller", 127); err != nil {
+				return nil, err
+			}
+			d1 = byte(v)
+			if v, err = parseMidiNumber(params[2], "value", 127); err != nil {
+				return nil, err
+			}
+			ret = append(ret, []byte{0xB0 | ch, d1, byte(v)})
+		case "pc":
+			if err = need("CH", "PROGRAM"); err != nil {
+				return nil, err
+			}
+			if ch, err = parseMidiChannel(params[0]); err != nil {
+				return nil, err
+			}
+			if v, err = parseMidiNumber(params[1], "program", 127); err != nil {
+				return nil, err
+			}
+			ret = append(ret, []byte{0xC0 | ch, byte(v)})
+		case "bend":
+			if err = need("CH", "VALUE"); err != nil {
+				return nil, err
+			}
+			if ch, err = parseMidiChannel(params[0]); err != nil {
+				return nil, err
+			}
+			if v, err = parseMidiNumber(params[1], "value", 16383); err != nil {
+				return nil, err
+			}
+			ret = append(ret, []byte{0xE0 | ch, byte(v & 0x7F), byte(v >> 7)})
+		case "hex":
+			if err = need("BYTES"); err != nil {
+				return nil, err
+			}
+			b, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "", ",", "").Replace(params[0]))
+			if err != nil || len(b) == 0 {
+				return nil, fmt.Errorf("invalid hex bytes %q", params[0])
+			}
+			ret = append(ret, b)
+		case "sysex":
+			if err = need("FILE"); err != nil {
+				return nil, err
+			}
+			b, err := os.ReadFile(params[0])
+			if err != nil {
+				return nil, err
+			}
+			messages, err := parseSyx(b)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", params[0], err)
+			}
+			ret = append(ret, messages...)
+		default:
+			return nil, fmt.Errorf("unknown message %q", cmd)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseMidiMessages(t *testing.T) {
+	syx := filepath.Join(t.TempDir(), "patch.syx")
+	if err := os.WriteFile(syx, []byte{0xF0, 0x41, 0x10, 0xF7, 0xF0, 0x7E, 0xF7}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args []string
+		want [][]byte
+	}{
+		{[]string{"note-on", "1", "60"}, [][]byte{{0x90, 60, 100}}},
+		{[]string{"note-on", "10", "C4", "127", "note-off", "10", "c4"}, [][]byte{{0x99, 60, 127}, {0x89, 60, 0}}},
+		{[]string{"note-on", "16", "F#2", "0x40"}, [][]byte{{0x9F, 42, 0x40}}},
+		{[]string{"note-off", "1", "Bb3", "64"}, [][]byte{{0x80, 58, 64}}},
+		{[]string{"note-on", "1", "C-1", "note-on", "1", "G9"}, [][]byte{{0x90, 0, 100}, {0x90, 127, 100}}},
+		{[]string{"cc", "2", "7", "100", "pc", "2", "5"}, [][]byte{{0xB1, 7, 100}, {0xC1, 5}}},
+		{[]string{"bend", "1", "8192", "bend", "1", "16383"}, [][]byte{{0xE0, 0, 0x40}, {0xE0, 0x7F, 0x7F}}},
+		{[]string{"hex", "f0 7e 7f 06 01 f7", "hex", "B0:07:7F"}, [][]byte{{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}, {0xB0, 0x07, 0x7F}}},
+		{[]string{"sysex", syx}, [][]byte{{0xF0, 0x41, 0x10, 0xF7}, {0xF0, 0x7E, 0xF7}}},
+	}
+	for _, tt := range tests {
+		got, err := parseMidiMessages(tt.args)
+		if err != nil {
+			t.Errorf("parseMidiMessages(%q) failed: %v", tt.args, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseMidiMessages(%q) = % x, want % x", tt.args, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], tt.want[i]) {
+				t.Errorf("parseMidiMessages(%q) = % x, want % x", tt.args, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, args := range [][]string{
+		{"note-on", "1"},
+		{"note-on", "0", "60"},
+		{"note-on", "17", "60"},
+		{"note-on", "1", "128"},
+		{"note-on", "1", "H4"},
+		{"note-on", "1", "G#9"},
+		{"note-on", "1", "60", "128"},
+		{"cc", "1", "7"},
+		{"pc", "1", "0x80"},
+		{"bend", "1", "16384"},
+		{"hex", "f0 7"},
+		{"hex", ""},
+		{"sysex", syx + ".missing"},
+		{"note", "1", "60"},
+	} {
+		if got, err := parseMidiMessages(args); err == nil {
+			t.Errorf("parseMidiMessages(%q) = % x, want an error", args, got)
+		}
+	}
+}
+
+func TestParseSyx(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		{0x41, 0xF7},
+		{0xF0, 0x41},
+		{0xF0, 0x41, 0x90, 0xF7},
+		{0xF0, 0xF7, 0x00},
+	} {
+		if got, err := parseSyx(b); err == nil {
+			t.Errorf("parseSyx(% x) = % x, want an error", b, got)
+		}
+	}
+}
+
+func TestMidiSend(t *testing.T) {
+	origDev, origSys, origPorts := sndDevPath, sysfsSoundPath, getMidiPortsFn
+	defer func() { sndDevPath, sysfsSoundPath, getMidiPortsFn = origDev, origSys, origPorts }()
+	sndDevPath = t.TempDir()
+	sysfsSoundPath = t.TempDir()
+	getMidiPortsFn = noMidiPorts
+
+	for _, name := range []string{"midiC30D0", "midiC31D0"} {
+		if err := os.WriteFile(filepath.Join(sndDevPath, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr, _ := captureOutput(func() {
+		if code := midiSendMain([]string{"midi-send", "-v", "card 31", "note-on", "1", "C4", "hex", "f0 7e 7f 06 01 f7"}); code != 0 {
+			t.Errorf("exit code = %d", code)
+		}
+	})
+	path := filepath.Join(sndDevPath, "midiC31D0")
+	want := "Sent to " + path + " (MIDI Card 31 Device 0): 90 3c 64\n" +
+		"Sent to " + path + " (MIDI Card 31 Device 0): f0 7e 7f 06 01 f7\n"
+	if stdout != want || stderr != "" {
+		t.Errorf("stdout = %q, want %q; stderr = %q", stdout, want, stderr)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, []byte{0x90, 0x3C, 0x64, 0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}) {
+		t.Errorf("sent % x", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(sndDevPath, "midiC30D0")); len(got) != 0 {
+		t.Errorf("sent % x to an unselected device", got)
+	}
+
+	stdout, _, _ = captureOutput(func() {
+		if code := midiSendMain([]string{"midi-send", "-n", "card 3", "pc", "1", "5"}); code != 0 {
+			t.Errorf("exit code = %d", code)
+		}
+	})
+	if strings.Count(stdout, "Would send to ") != 2 || !strings.Contains(stdout, ": c0 05\n") {
+		t.Errorf("dry run printed %q", stdout)
+	}
+
+	stdout, _, _ = captureOutput(func() {
+		if code := midiSendMain([]string{"midi-send", "nothing", "pc", "1", "5"}); code != 1 {
+			t.Errorf("exit code = %d, want 1", code)
+		}
+	})
+	if stdout != "No devices selected.\n" {
+		t.Errorf("stdout = %q", stdout)
+	}
+	for _, args := range [][]string{
+		{"midi-send", "card 31"},
+		{"midi-send", "card 31", "pc", "1"},
+		{"midi-send", "--timeout", "1s", "card 31", "pc", "1", "5"},
+		{"midi-send", "--no-such-flag", "card 31", "pc", "1", "5"},
+		{"midi-send", "-w", "card 31", "pc", "1", "5"}, // -w is --where in the main command.
+	} {
+		captureOutput(func() {
+			if code := midiSendMain(args); code != 2 {
+				t.Errorf("midiSendMain(%q) = %d, want 2", args, code)
+			}
+		})
+	}
+
+	// With --watch, the input is monitored while sending; a FIFO echoes the messages back.
+	fifo := filepath.Join(sndDevPath, "midiC32D0")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skipf("Cannot create a FIFO: %v", err)
+	}
+	stdout, _, _ = captureOutput(func() {
+		midiSendMain([]string{"midi-send", "--no-color", "--watch", "-t", "200ms", "card 32", "cc", "1", "7", "100"})
+	})
+	if !strings.Contains(stdout, "MIDI: Control Change (Ch 1) - Controller 7 (Main Volume), Value 100") {
+		t.Errorf("watch printed %q", stdout)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+// Modifier state for simple mode. By default, the modifiers of an event only come from
+// the device that sent it. --global-mods combines the modifiers of all the selected devices,
+// and --mod-group combines them within named groups of devices, so a Shift on one half of
+// a split keyboard, or on a foot pedal, applies to the letters typed on another device.
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+var modifierKeys = []evdev.EvCode{
+	evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT,
+	evdev.KEY_LEFTCTRL, evdev.KEY_RIGHTCTRL,
+	evdev.KEY_LEFTALT, evdev.KEY_RIGHTALT,
+	evdev.KEY_LEFTMETA, evdev.KEY_RIGHTMETA,
+}
+
+type modGroup struct {
+	name string
+	sel  *evutil.CombinedSelector
+}
+
+var modGroups []modGroup
+
+// path -> scope. Devices with the same scope share their modifier state.
+var modScopes = make(map[string]string)
+
+// parseModGroups parses the NAME=FILTER arguments of --mod-group. The filters of the
+// same NAME are combined like the positional filters.
+func parseModGroups(specs []string) ([]modGroup, error) {
+	ret := make([]modGroup, 0, len(specs))
+	for _, spec := range specs {
+		name, filter, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || filter == "" {
+			return nil, fmt.Errorf("invalid --mod-group %q: must be NAME=FILTER", spec)
+		}
+		i := slices.IndexFunc(ret, func(g modGroup) bool { return g.name == name })
+		if i < 0 {
+			ret = append(ret, modGroup{name: name, sel: evutil.NewCombinedSelector()})
+			i = len(ret) - 1
+		}
+		sel, err := parseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		ret[i].sel.Add(sel)
+	}
+	return ret, nil
+}
+
+// modScope returns the modifier scope of a device.
+func modScope(path, name string) string {
+	if scope, ok := modScopes[path]; ok {
+		return scope
+	}
+	scope := "path:" + path
+	d := matchableDevice(path, name)
+	if i := slices.IndexFunc(modGroups, func(g modGroup) bool { return evutil.Matches(g.sel, d) }); i >= 0 {
+		scope = "group:" + modGroups[i].name
+	} else if *globalMods {
+		scope = "global"
+	}
+	modScopes[path] = scope
+	return scope
+}
+
+// modScopePaths returns the sorted paths of the devices sharing their modifier state with path.
+func modScopePaths(path, name string) []string {
+	scope := modScope(path, name)
+	ret := []string{path}
+	for p := range deviceStates {
+		if p != path && modScopes[p] == scope {
+			ret = append(ret, p)
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// scopeKeyState returns 1 if any of keys is pressed on any of the devices.
+func scopeKeyState(paths []string, keys ...evdev.EvCode) int32 {
+	for _, p := range paths {
+		for _, k := range keys {
+			if getDeviceState(p).keys[k] > 0 {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// scopeLedState returns 1 if led is on on any of the devices.
+func scopeLedState(paths []string, led evdev.EvCode) int32 {
+	for _, p := range paths {
+		if getDeviceState(p).leds[led] > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// modSources returns the pressed modifiers and their devices, e.g. "KEY_LEFTSHIFT@/dev/input/event3",
+// or "-" if there's none.
+func modSources(paths []string) string {
+	sources := make([]string, 0)
+	for _, p := range paths {
+		for _, k := range modifierKeys {
+			if getDeviceState(p).keys[k] > 0 {
+				sources = append(sources, evdev.CodeName(evdev.EV_KEY, k)+"@"+p)
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return "-"
+	}
+	return strings.Join(sources, ",")
+}
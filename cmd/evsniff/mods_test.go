@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestModifierScopes(t *testing.T) {
+	defer func() {
+		modGroups = nil
+		clear(modScopes)
+		clear(deviceStates)
+		*globalMods = false
+	}()
+
+	groups, err := parseModGroups([]string{"split=ergodox", "split=pedal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modGroups = groups
+
+	keyboard := "/dev/input/event10"
+	left := "/dev/input/event11"
+	pedal := "/dev/input/event12"
+	modScope(keyboard, "AT Translated Keyboard")
+	modScope(left, "ErgoDox EZ")
+	modScope(pedal, "USB Foot Pedal")
+
+	updateDeviceState(keyboard, &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_LEFTCTRL, Value: 1})
+	updateDeviceState(pedal, &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_LEFTSHIFT, Value: 1})
+	updateDeviceState(pedal, &evdev.InputEvent{Type: evdev.EV_LED, Code: evdev.LED_NUML, Value: 1})
+
+	paths := modScopePaths(left, "ErgoDox EZ")
+	if got := scopeKeyState(paths, evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT); got != 1 {
+		t.Errorf("shift from the group = %d, want 1", got)
+	}
+	if got := scopeKeyState(paths, evdev.KEY_LEFTCTRL, evdev.KEY_RIGHTCTRL); got != 0 {
+		t.Errorf("ctrl from outside the group = %d, want 0", got)
+	}
+	if got := scopeLedState(paths, evdev.LED_NUML); got != 1 {
+		t.Errorf("numlock = %d, want 1", got)
+	}
+	if got, want := modSources(paths), "KEY_LEFTSHIFT@"+pedal; got != want {
+		t.Errorf("modSources() = %q, want %q", got, want)
+	}
+
+	// Without --global-mods, other devices don't count.
+	if got := modSources(modScopePaths(keyboard, "AT Translated Keyboard")); got != "KEY_LEFTCTRL@"+keyboard {
+		t.Errorf("modSources() = %q", got)
+	}
+}
+
+func TestGlobalMods(t *testing.T) {
+	defer func() {
+		clear(modScopes)
+		clear(deviceStates)
+		*globalMods = false
+	}()
+	*globalMods = true
+
+	updateDeviceState("/dev/input/event1", &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_RIGHTALT, Value: 1})
+	modScope("/dev/input/event1", "Keyboard")
+
+	paths := modScopePaths("/dev/input/event2", "Macro Pad")
+	if got := scopeKeyState(paths, evdev.KEY_RIGHTALT); got != 1 {
+		t.Errorf("altgr = %d, want 1", got)
+	}
+	if got := modSources(paths); got != "KEY_RIGHTALT@/dev/input/event1" {
+		t.Errorf("modSources() = %q", got)
+	}
+}
+
+func TestParseModGroupsError(t *testing.T) {
+	for _, spec := range []string{"split", "=ergodox", "split="} {
+		if _, err := parseModGroups([]string{spec}); err == nil {
+			t.Errorf("parseModGroups(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestModGroupByAttribute(t *testing.T) {
+	usbDev, _ := setupGroupSysfs(t)
+	writeSysfsFiles(t, filepath.Join(usbDev, "1-2:1.0", "0003:046D:C31C.0001", "input", "input3"),
+		map[string]string{"id/bustype": "0003", "id/vendor": "046d", "id/product": "c31c"})
+	defer func() {
+		modGroups = nil
+		clear(modScopes)
+	}()
+
+	groups, err := parseModGroups([]string{"logi=vendor=046d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modGroups = groups
+	for _, tt := range []struct{ path, name, want string }{
+		{"/dev/input/event3", "Logitech USB Keyboard", "group:logi"},
+		{"/dev/input/event20", "Virtual Keyboard", "path:/dev/input/event20"},
+	} {
+		if got := modScope(tt.path, tt.name); got != tt.want {
+			t.Errorf("modScope(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+// ALSA rawmidi ioctls, for the port and subdevice names that /proc/asound/cards doesn't have.
+// See include/uapi/sound/asound.h.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	sndrvRawmidiStreamInput = 1
+
+	// Modes of snd_rawmidi_params, since rawmidi protocol 2.0.2 (Linux 5.14).
+	sndrvRawmidiModeFramingTstamp = 1 << 0
+	sndrvRawmidiModeClockRealtime = 1 << 3
+
+	// The size of struct snd_rawmidi_framing_tstamp, and of its data.
+	rawmidiFrameSize     = 32
+	rawmidiFrameDataSize = 16
+
+	// rawmidiBufferSize is the kernel buffer size for framed input. It's also the default
+	// size in the non-framed mode, but each frame holds at most 16 bytes.
+	rawmidiBufferSize = 4096
+)
+
+// sndrvProtocolVersion is SNDRV_PROTOCOL_VERSION().
+func sndrvProtocolVersion(major, minor, subminor int32) int32 {
+	return major<<16 | minor<<8 | subminor
+}
+
+// rawmidiInfo is struct snd_rawmidi_info.
+type rawmidiInfo struct {
+	device          uint32
+	subdevice       uint32
+	stream          int32
+	card            int32
+	flags           uint32
+	id              [64]byte
+	name            [80]byte
+	subname         [32]byte
+	subdevicesCount uint32
+	subdevicesAvail uint32
+	reserved        [64]byte
+}
+
+// rawmidiParams is struct snd_rawmidi_params. uint is size_t.
+type rawmidiParams struct {
+	stream          int32
+	bufferSize      uint
+	availMin        uint
+	noActiveSensing uint32 // A 1-bit bitfield.
+	mode            uint32
+	reserved        [12]byte
+}
+
+var (
+	sndrvCtlIoctlRawmidiInfo            = ioc(iocRead|iocWrite, 'U', 0x41, uint32(unsafe.Sizeof(rawmidiInfo{})))
+	sndrvCtlIoctlRawmidiPreferSubdevice = ioc(iocWrite, 'U', 0x42, 4)
+	sndrvRawmidiIoctlPversion           = ioc(iocRead, 'W', 0x00, 4)
+	sndrvRawmidiIoctlParams             = ioc(iocRead|iocWrite, 'W', 0x10, uint32(unsafe.Sizeof(rawmidiParams{})))
+)
+
+// midiPorts is the name of a rawmidi device and its subdevices, e.g. "UM-ONE MIDI 1", or
+// "DIN", "USB" and "Editor" for an interface with multiple ports.
+type midiPorts struct {
+	name       string
+	subdevices []string
+}
+
+// sndDevPath is where the ALSA device nodes are.
+var sndDevPath = "/dev/snd"
+
+// getMidiPortsFn returns the port names of a rawmidi device; it's replaced in tests.
+var getMidiPortsFn = getMidiPorts
+
+// getMidiPorts asks the control device of the card for the input port names of a rawmidi
+// device. The control device can be queried without opening the rawmidi device, which
+// would take one of its subdevices.
+func getMidiPorts(card, device int) (midiPorts, error) {
+	path := fmt.Sprintf("%s/controlC%d", sndDevPath, card)
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return midiPorts{}, err
+	}
+	defer syscall.Close(fd)
+
+	var ret midiPorts
+	for sub := uint32(0); ; sub++ {
+		info := rawmidiInfo{device: uint32(device), subdevice: sub, stream: sndrvRawmidiStreamInput}
+		if err := doRawIoctl(uintptr(fd), sndrvCtlIoctlRawmidiInfo, unsafe.Pointer(&info)); err != nil {
+			if sub == 0 {
+				return midiPorts{}, err
+			}
+			break
+		}
+		if sub == 0 {
+			ret.name = cString(info.name[:])
+		}
+		ret.subdevices = append(ret.subdevices, cString(info.subname[:]))
+		if sub+1 >= info.subdevicesCount {
+			break
+		}
+	}
+	return ret, nil
+}
+
+// openRawmidi opens a rawmidi device node. The kernel gives it the first free subdevice, unless
+// sub isn't -1: then that subdevice is requested on the control device of the card first, and
+// the open fails if it's busy.
+func openRawmidi(path string, sub, flags int) (int, error) {
+	if sub >= 0 {
+		card, _, err := parseMidiPath(path)
+		if err != nil {
+			return -1, err
+		}
+		// The kernel looks the request up by the opening thread, while the control device is open.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		ctlPath := fmt.Sprintf("%s/controlC%d", sndDevPath, card)
+		ctl, err := syscall.Open(ctlPath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, &os.PathError{Op: "open", Path: ctlPath, Err: err}
+		}
+		defer syscall.Close(ctl)
+		pref := int32(sub)
+		if err := doRawIoctl(uintptr(ctl), sndrvCtlIoctlRawmidiPreferSubdevice, unsafe.Pointer(&pref)); err != nil {
+			return -1, fmt.Errorf("cannot select subdevice %d of %s: %w", sub, path, err)
+		}
+	}
+	fd, err := syscall.Open(path, flags|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return fd, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// enableMidiTimestamps switches an open rawmidi input to the framing mode, in which the kernel
+// stamps the received bytes with CLOCK_REALTIME, the clock of evdev events.
+func enableMidiTimestamps(fd int) error {
+	var version int32
+	if err := doRawIoctl(uintptr(fd), sndrvRawmidiIoctlPversion, unsafe.Pointer(&version)); err != nil {
+		return err
+	}
+	// Older kernels ignore the mode, which was a reserved field.
+	if version < sndrvProtocolVersion(2, 0, 2) {
+		return fmt.Errorf("rawmidi protocol %d.%d.%d doesn't support timestamps", version>>16, version>>8&0xff, version&0xff)
+	}
+	params := rawmidiParams{
+		stream:     sndrvRawmidiStreamInput,
+		bufferSize: rawmidiBufferSize,
+		availMin:   1,
+		mode:       sndrvRawmidiModeFramingTstamp | sndrvRawmidiModeClockRealtime,
+	}
+	return doRawIoctl(uintptr(fd), sndrvRawmidiIoctlParams, unsafe.Pointer(&params))
+}
+
+// decodeMidiFrames calls f with each byte in the struct snd_rawmidi_framing_tstamp records in b,
+// and their timestamps. A read returns whole records.
+func decodeMidiFrames(b []byte, f func(b byte, ts time.Time)) {
+	for ; len(b) >= rawmidiFrameSize; b = b[rawmidiFrameSize:] {
+		frameType, length := b[0], int(b[1])
+		if frameType != 0 || length > rawmidiFrameDataSize {
+			continue // Not SNDRV_RAWMIDI_FRAME_TYPE_DEFAULT.
+		}
+		nsec := binary.NativeEndian.Uint32(b[4:8])
+		sec := binary.NativeEndian.Uint64(b[8:16])
+		ts := time.Unix(int64(sec), int64(nsec))
+		for _, c := range b[16 : 16+length] {
+			f(c, ts)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+// A single-threaded epoll event loop that watches all the evdev, rawmidi and hotplug
+// file descriptors at once.
+//
+// We don't use os.File and goroutines for reading devices, because a goroutine per device
+// blocked in a read, plus the Go runtime netpoller, adds noticeable startup and wakeup
+// overhead with dozens of (virtual) input devices. See also the comment in dumpkeys.go.
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// reactorSource is a file descriptor watched by the reactor.
+type reactorSource interface {
+	// fd returns the file descriptor to watch.
+	fd() int
+
+	// onReadable is called when the file descriptor is readable (or has an error or hang-up).
+	// Returning an error removes and closes the source.
+	onReadable() error
+
+	// close releases the source. It's called when the source is removed from the reactor.
+	close()
+}
+
+type reactorTimer struct {
+	deadline time.Time
+	f        func()
+}
+
+type reactor struct {
+	epfd    int
+	sources map[int]reactorSource
+
+	// background holds the file descriptors of sources that don't keep the loop running,
+	// such as the hotplug watcher.
+	background map[int]bool
+
+	timers []reactorTimer
+
+	// holds is the number of things that keep the loop running without a source, such as
+	// devices waiting to be reattached. See hold.
+	holds int
+
+	// stopped is set by stop, to exit the loop.
+	stopped bool
+}
+
+func newReactor() (*reactor, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &reactor{
+		epfd:       epfd,
+		sources:    make(map[int]reactorSource),
+		background: make(map[int]bool),
+	}, nil
+}
+
+// add starts watching s. The loop keeps running as long as there's any source added with add.
+func (r *reactor) add(s reactorSource) error {
+	fd := s.fd()
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err := syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		return err
+	}
+	r.sources[fd] = s
+	return nil
+}
+
+// addBackground starts watching s, without keeping the loop running.
+func (r *reactor) addBackground(s reactorSource) error {
+	if err := r.add(s); err != nil {
+		return err
+	}
+	r.background[s.fd()] = true
+	return nil
+}
+
+// remove stops watching s and closes it.
+func (r *reactor) remove(s reactorSource) {
+	fd := s.fd()
+	if _, ok := r.sources[fd]; !ok {
+		return
+	}
+	_ = syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+	delete(r.sources, fd)
+	delete(r.background, fd)
+	s.close()
+}
+
+// close removes and closes all the sources, and closes the epoll instance.
+func (r *reactor) close() {
+	for _, s := range r.sources {
+		r.remove(s)
+	}
+	r.timers = nil
+	_ = syscall.Close(r.epfd)
+	r.epfd = -1
+}
+
+// after calls f on the loop after d.
+func (r *reactor) after(d time.Duration, f func()) {
+	r.timers = append(r.timers, reactorTimer{deadline: time.Now().Add(d), f: f})
+	slices.SortStableFunc(r.timers, func(a, b reactorTimer) int {
+		return a.deadline.Compare(b.deadline)
+	})
+}
+
+// every calls f on the loop every d.
+func (r *reactor) every(d time.Duration, f func()) {
+	r.after(d, func() {
+		f()
+		r.every(d, f)
+	})
+}
+
+// hold keeps the loop running until release is called, even without any source added with add,
+// e.g. while a device is waiting for a timer or hotplug to reattach it.
+func (r *reactor) hold() {
+	r.holds++
+}
+
+// release undoes hold.
+func (r *reactor) release() {
+	r.holds--
+}
+
+func (r *reactor) foregroundCount() int {
+	return len(r.sources) - len(r.background) + r.holds
+}
+
+// runTimers calls the expired timers and returns the epoll timeout until the next one.
+func (r *reactor) runTimers() int {
+	for len(r.timers) > 0 {
+		now := time.Now()
+		t := r.timers[0]
+		if t.deadline.After(now) {
+			// Round up, so we don't wake up right before the deadline.
+			return int((t.deadline.Sub(now) + time.Millisecond - 1) / time.Millisecond)
+		}
+		r.timers = r.timers[1:]
+		t.f()
+	}
+	return -1
+}
+
+// stop makes run return after the current handler.
+func (r *reactor) stop() {
+	r.stopped = true
+}
+
+// signalSource wakes up the loop when a signal is received, with a pipe written to by a goroutine.
+type signalSource struct {
+	r      *reactor
+	rfd    int
+	wfd    int
+	ch     chan os.Signal
+	handle func(sig os.Signal)
+}
+
+var _ reactorSource = (*signalSource)(nil)
+
+// onSignal calls handle on the loop when one of sigs is received, instead of the default action.
+func (r *reactor) onSignal(handle func(sig os.Signal), sigs ...os.Signal) error {
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		return err
+	}
+	s := &signalSource{r: r, rfd: p[0], wfd: p[1], ch: make(chan os.Signal, 1), handle: handle}
+	if err := r.addBackground(s); err != nil {
+		s.close()
+		return err
+	}
+	signal.Notify(s.ch, sigs...)
+	go func() {
+		for sig := range s.ch {
+			// The signal number is small enough for a byte.
+			_, _ = syscall.Write(s.wfd, []byte{byte(sig.(syscall.Signal))})
+		}
+	}()
+	return nil
+}
+
+func (s *signalSource) fd() int {
+	return s.rfd
+}
+
+func (s *signalSource) onReadable() error {
+	var buf [16]byte
+	n, err := syscall.Read(s.rfd, buf[:])
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, b := range buf[:n] {
+		s.handle(syscall.Signal(b))
+	}
+	return nil
+}
+
+func (s *signalSource) close() {
+	signal.Stop(s.ch)
+	close(s.ch)
+	_ = syscall.Close(s.rfd)
+	_ = syscall.Close(s.wfd)
+}
+
+// run dispatches events until there are no foreground sources or holds left, or stop is called.
+func (r *reactor) run() {
+	events := make([]syscall.EpollEvent, 64)
+	for r.foregroundCount() > 0 && !r.stopped {
+		timeout := r.runTimers()
+		if r.stopped {
+			return // Stopped by a timer.
+		}
+
+		n, err := syscall.EpollWait(r.epfd, events, timeout)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "epoll_wait failed: %v\n", err)
+			return
+		}
+		for _, ev := range events[:n] {
+			s, ok := r.sources[int(ev.Fd)]
+			if !ok {
+				continue // Removed by a previous handler.
+			}
+			if err := s.onReadable(); err != nil {
+				r.remove(s)
+			}
+			if r.stopped {
+				return
+			}
+		}
+	}
+}
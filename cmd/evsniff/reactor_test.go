@@ -0,0 +1,86 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// idleSource is a reactorSource for the read end of a pipe.
+type idleSource struct {
+	rfd    int
+	closed bool
+}
+
+func (s *idleSource) fd() int {
+	return s.rfd
+}
+
+func (s *idleSource) onReadable() error {
+	return nil
+}
+
+func (s *idleSource) close() {
+	_ = syscall.Close(s.rfd)
+	s.closed = true
+}
+
+// newIdleSource returns a source that never becomes readable, and the write end of its pipe.
+func newIdleSource(t *testing.T) (*idleSource, int) {
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	return &idleSource{rfd: p[0]}, p[1]
+}
+
+func TestReactorStopFromTimer(t *testing.T) {
+	r, err := newReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	s, wfd := newIdleSource(t)
+	defer syscall.Close(wfd)
+	if err := r.add(s); err != nil {
+		t.Fatal(err)
+	}
+	r.after(10*time.Millisecond, r.stop)
+
+	done := make(chan bool)
+	go func() {
+		r.run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop from a timer didn't exit the loop")
+	}
+}
+
+func TestReactorClose(t *testing.T) {
+	r, err := newReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fg, wfd1 := newIdleSource(t)
+	defer syscall.Close(wfd1)
+	bg, wfd2 := newIdleSource(t)
+	defer syscall.Close(wfd2)
+	if err := r.add(fg); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.addBackground(bg); err != nil {
+		t.Fatal(err)
+	}
+	epfd := r.epfd
+
+	r.close()
+	if !fg.closed || !bg.closed || len(r.sources) != 0 || len(r.background) != 0 {
+		t.Errorf("sources aren't closed: %v %v %v", fg.closed, bg.closed, r.sources)
+	}
+	if _, err := syscall.EpollWait(epfd, make([]syscall.EpollEvent, 1), 0); err != syscall.EBADF {
+		t.Errorf("epoll fd isn't closed: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+// Standard MIDI Files, for --record-midi and --play-midi.
+//
+// A recording is a Type 1 file with a conductor track, which has the tempo and the time
+// signature, and one track per MIDI device. The events are kept in memory and the file is
+// written when evsniff exits, because the track lengths come before the events.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// smfDivision is the number of ticks per quarter note.
+	smfDivision = 480
+
+	// smfTempo is the tempo of recordings in microseconds per quarter note, i.e. 120 BPM,
+	// which makes a tick about 1 ms.
+	smfTempo = 500000
+)
+
+// smfEvent is a MIDI message in a track.
+type smfEvent struct {
+	ts   time.Time
+	data []byte // The message, starting with the status byte.
+}
+
+// smfTrack is the events from one device.
+type smfTrack struct {
+	name   string
+	events []smfEvent
+}
+
+// midiRecorder collects the events for --record-midi.
+type midiRecorder struct {
+	file   *os.File
+	tracks []*smfTrack
+	byPath map[string]*smfTrack
+}
+
+// midiRec is the recorder for --record-midi, or nil.
+var midiRec *midiRecorder
+
+// newMidiRecorder creates the file up front, so errors are reported before recording anything.
+func newMidiRecorder(path string) (*midiRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &midiRecorder{file: f, byPath: make(map[string]*smfTrack)}, nil
+}
+
+// track returns the track of the device d. A device that's reattached gets the same track.
+func (m *midiRecorder) track(d *MidiDevice) *smfTrack {
+	if t, ok := m.byPath[d.path]; ok {
+		return t
+	}
+	t := &smfTrack{name: d.name}
+	m.tracks = append(m.tracks, t)
+	m.byPath[d.path] = t
+	return t
+}
+
+// record adds ev to the track, unless it's a real-time message and --midi-drop-realtime is given.
+func (t *smfTrack) record(ev MidiEvent) {
+	if ev.Type == "RealTime" && *dropRealtime {
+		return
+	}
+	t.events = append(t.events, smfEvent{ev.Timestamp, midiMessageBytes(ev)})
+}
+
+// close writes the file.
+func (m *midiRecorder) close() error {
+	w := bufio.NewWriter(m.file)
+	err := writeSmf(w, m.tracks)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := m.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && *verbose {
+		fmt.Printf("Wrote %d MIDI tracks to %s\n", len(m.tracks), m.file.Name())
+	}
+	return err
+}
+
+// midiMessageBytes returns the bytes of a MIDI message, as received.
+func midiMessageBytes(ev MidiEvent) []byte {
+	if ev.SysEx != nil {
+		return ev.SysEx
+	}
+	n := 0
+	if ev.Status >= 0xF0 {
+		n = getSystemCommonLen(ev.Status)
+	} else {
+		n = getChannelMessageLen(ev.Status)
+	}
+	return []byte{ev.Status, ev.Data1, ev.Data2}[:1+n]
+}
+
+func appendVarLen(b []byte, v uint32) []byte {
+	var buf [5]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7F)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7F) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
+
+func appendMeta(b []byte, delta uint32, typ byte, data []byte) []byte {
+	b = appendVarLen(b, delta)
+	b = append(b, 0xFF, typ)
+	b = appendVarLen(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// appendSmfEvent appends a MIDI message. SysEx messages are written as F0 <length> <the rest>,
+// and other system messages, which files can't have as is, as F7 <length> <message> escapes.
+func appendSmfEvent(b []byte, delta uint32, data []byte) []byte {
+	b = appendVarLen(b, delta)
+	switch {
+	case data[0] == 0xF0:
+		b = append(b, 0xF0)
+		b = appendVarLen(b, uint32(len(data)-1))
+		return append(b, data[1:]...)
+	case data[0] >= 0xF0:
+		b = append(b, 0xF7)
+		b = appendVarLen(b, uint32(len(data)))
+	}
+	return append(b, data...)
+}
+
+// ticksSince converts a duration to ticks at smfTempo.
+func ticksSince(origin, ts time.Time) int64 {
+	d := ts.Sub(origin)
+	if d < 0 {
+		return 0
+	}
+	return (d.Nanoseconds()*smfDivision + smfTempo*500) / (smfTempo * 1000)
+}
+
+func writeSmfChunk(w io.Writer, typ string, data []byte) error {
+	header := make([]byte, 8)
+	copy(header, typ)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writeSmf writes a Type 1 file. Time 0 is the first event of all the tracks.
+func writeSmf(w io.Writer, tracks []*smfTrack) error {
+	var origin time.Time
+	for _, t := range tracks {
+		if len(t.events) > 0 && (origin.IsZero() || t.events[0].ts.Before(origin)) {
+			origin = t.events[0].ts
+		}
+	}
+
+	header := make([]byte, 6)
+	binary.BigEndian.PutUint16(header[0:], 1)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(tracks)+1))
+	binary.BigEndian.PutUint16(header[4:], smfDivision)
+	if err := writeSmfChunk(w, "MThd", header); err != nil {
+		return err
+	}
+
+	// The conductor track.
+	var b []byte
+	b = appendMeta(b, 0, 0x03, []byte("evsniff"))
+	b = appendMeta(b, 0, 0x51, []byte{smfTempo >> 16, smfTempo >> 8 & 0xFF, smfTempo & 0xFF})
+	b = appendMeta(b, 0, 0x58, []byte{4, 2, 24, 8}) // 4/4
+	b = appendMeta(b, 0, 0x2F, nil)
+	if err := writeSmfChunk(w, "MTrk", b); err != nil {
+		return err
+	}
+
+	for _, t := range tracks {
+		b = appendMeta(b[:0], 0, 0x03, []byte(t.name))
+		var last int64
+		for _, e := range t.events {
+			// Keep the order of the events, even if the clock goes backwards.
+			tick := max(ticksSince(origin, e.ts), last)
+			b = appendSmfEvent(b, uint32(tick-last), e.data)
+			last = tick
+		}
+		b = appendMeta(b, 0, 0x2F, nil)
+		if err := writeSmfChunk(w, "MTrk", b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// smfFileEvent is an event in a track of a file.
+type smfFileEvent struct {
+	tick     uint64
+	isMeta   bool
+	metaType byte
+
+	// For MIDI events, the bytes to feed to MidiParser: a message, "F0 ..." for SysEx,
+	// or the escaped bytes of "F7 <length> <bytes>", which can be a part of a SysEx message.
+	// For meta events, the data.
+	data []byte
+}
+
+// smfFile is a file read by readSmf.
+type smfFile struct {
+	format   uint16
+	division uint16
+	tracks   [][]smfFileEvent
+}
+
+var errSmfTruncated = errors.New("truncated file")
+
+func readVarLen(b []byte) (uint32, int, error) {
+	var v uint32
+	for i := 0; i < len(b) && i < 4; i++ {
+		v = v<<7 | uint32(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("invalid variable-length quantity")
+}
+
+// readSmfData reads a variable-length quantity and that many bytes from b, and returns them and
+// the rest of b.
+func readSmfData(b []byte) ([]byte, []byte, error) {
+	n, size, err := readVarLen(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	b = b[size:]
+	if uint64(n) > uint64(len(b)) {
+		return nil, nil, errSmfTruncated
+	}
+	return b[:n], b[n:], nil
+}
+
+// readSmf reads a Standard MIDI File of any format.
+func readSmf(r io.Reader) (*smfFile, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 14 || string(b[:4]) != "MThd" {
+		return nil, errors.New("not a Standard MIDI File")
+	}
+	headerLen := binary.BigEndian.Uint32(b[4:8])
+	if headerLen < 6 || uint64(headerLen) > uint64(len(b)-8) {
+		return nil, errors.New("invalid header")
+	}
+	f := &smfFile{
+		format:   binary.BigEndian.Uint16(b[8:10]),
+		division: binary.BigEndian.Uint16(b[12:14]),
+	}
+	if f.division&0x7FFF == 0 || (f.division&0x8000 != 0 && (f.division&0xFF == 0 || int8(f.division>>8) >= 0)) {
+		return nil, fmt.Errorf("invalid division 0x%04X", f.division)
+	}
+	b = b[8+headerLen:]
+
+	for len(b) >= 8 {
+		typ := string(b[:4])
+		size := binary.BigEndian.Uint32(b[4:8])
+		b = b[8:]
+		if uint64(size) > uint64(len(b)) {
+			return nil, errSmfTruncated
+		}
+		chunk := b[:size]
+		b = b[size:]
+		if typ != "MTrk" {
+			continue // Unknown chunks must be ignored.
+		}
+		events, err := parseSmfTrack(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", len(f.tracks), err)
+		}
+		f.tracks = append(f.tracks, events)
+	}
+	return f, nil
+}
+
+func parseSmfTrack(b []byte) ([]smfFileEvent, error) {
+	var events []smfFileEvent
+	var tick uint64
+	var running byte
+	for len(b) > 0 {
+		delta, size, err := readVarLen(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[size:]
+		tick += uint64(delta)
+		if len(b) == 0 {
+			return nil, errSmfTruncated
+		}
+
+		ev := smfFileEvent{tick: tick}
+		switch status := b[0]; {
+		case status == 0xFF:
+			if len(b) < 2 {
+				return nil, errSmfTruncated
+			}
+			ev.isMeta = true
+			ev.metaType = b[1]
+			if ev.data, b, err = readSmfData(b[2:]); err != nil {
+				return nil, err
+			}
+			running = 0
+		case status == 0xF0 || status == 0xF7:
+			var data []byte
+			if data, b, err = readSmfData(b[1:]); err != nil {
+				return nil, err
+			}
+			if status == 0xF0 {
+				ev.data = append([]byte{0xF0}, data...)
+			} else {
+				ev.data = data
+			}
+			running = 0
+		case status > 0xF0:
+			return nil, fmt.Errorf("invalid status 0x%02X at tick %d", status, tick)
+		default:
+			if status >= 0x80 {
+				running = status
+				b = b[1:]
+			} else if running == 0 {
+				return nil, fmt.Errorf("data byte 0x%02X without a status at tick %d", status, tick)
+			}
+			n := getChannelMessageLen(running)
+			if len(b) < n {
+				return nil, errSmfTruncated
+			}
+			ev.data = append([]byte{running}, b[:n]...)
+			b = b[n:]
+		}
+		events = append(events, ev)
+		if ev.isMeta && ev.metaType == 0x2F {
+			break // End of Track.
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+func TestAppendVarLen(t *testing.T) {
+	tests := []struct {
+		v    uint32
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0x81, 0x00}},
+		{0x2000, []byte{0xC0, 0x00}},
+		{0x1FFFFF, []byte{0xFF, 0xFF, 0x7F}},
+		{0x0FFFFFFF, []byte{0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+	for _, tt := range tests {
+		if got := appendVarLen(nil, tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("appendVarLen(%x) = % x, want % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRecordMidi(t *testing.T) {
+	resetFlags()
+	*dropRealtime = true
+	path := filepath.Join(t.TempDir(), "take1.mid")
+	m, err := newMidiRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Unix(1712345678, 0)
+	ms := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+
+	keys := m.track(&MidiDevice{path: "/dev/snd/midiC1D0", name: "Keys"})
+	pads := m.track(&MidiDevice{path: "/dev/snd/midiC2D0", name: "Pads"})
+	if m.track(&MidiDevice{path: "/dev/snd/midiC1D0", name: "Keys"}) != keys {
+		t.Error("a reattached device got a new track")
+	}
+	pads.record(MidiEvent{Timestamp: ms(0), Status: 0x99, Data1: 36, Data2: 127, Type: "NoteOn"})
+	keys.record(MidiEvent{Timestamp: ms(500), Status: 0x90, Data1: 60, Data2: 100, Type: "NoteOn"})
+	keys.record(MidiEvent{Timestamp: ms(600), Status: 0xF8, Type: "RealTime"}) // Dropped.
+	keys.record(MidiEvent{Timestamp: ms(1000), Status: 0xC0, Data1: 5, Type: "ProgramChange"})
+	keys.record(MidiEvent{Timestamp: ms(1000), Status: 0xF0, SysEx: []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}, Type: "SysEx"})
+	keys.record(MidiEvent{Timestamp: ms(999), Status: 0xF6, Type: "TuneRequest"}) // Out of order.
+
+	if err := m.close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("MThd\x00\x00\x00\x06\x00\x01\x00\x03\x01\xe0" +
+		"MTrk\x00\x00\x00\x1e" +
+		"\x00\xff\x03\x07evsniff" +
+		"\x00\xff\x51\x03\x07\xa1\x20" +
+		"\x00\xff\x58\x04\x04\x02\x18\x08" +
+		"\x00\xff\x2f\x00" +
+		"MTrk\x00\x00\x00\x21" +
+		"\x00\xff\x03\x04Keys" +
+		"\x83\x60\x90\x3c\x64" + // 500 ms = 480 ticks
+		"\x83\x60\xc0\x05" +
+		"\x00\xf0\x05\x7e\x7f\x06\x01\xf7" +
+		"\x00\xf7\x01\xf6" +
+		"\x00\xff\x2f\x00" +
+		"MTrk\x00\x00\x00\x10" +
+		"\x00\xff\x03\x04Pads" +
+		"\x00\x99\x24\x7f" +
+		"\x00\xff\x2f\x00")
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n% x\nwant\n% x", got, want)
+	}
+}
+
+func TestPlayMidi(t *testing.T) {
+	resetFlags()
+	clear(deviceLabels)
+	file := filepath.Join(t.TempDir(), "song.mid")
+	track := func(events string) string {
+		return "MTrk" + string([]byte{0, 0, 0, byte(len(events))}) + events
+	}
+	data := "MThd\x00\x00\x00\x06\x00\x01\x00\x02\x01\xe0" +
+		track("\x00\xff\x03\x05Tempo"+
+			"\x00\xff\x51\x03\x07\xa1\x20"+ // 120 BPM
+			"\x00\xff\x58\x04\x03\x02\x18\x08"+
+			"\x00\xff\x59\x02\xfd\x01"+
+			"\x83\x60\xff\x51\x03\x0f\x42\x40"+ // 60 BPM after a beat
+			"\x00\xff\x2f\x00") +
+		track("\x00\xff\x03\x05Drums"+
+			"\x00\x99\x24\x64"+
+			"\x83\x60\x26\x50"+ // Running status, 0.5 s
+			"\x83\x60\xb9\x07\x64"+ // 1.5 s: a beat at 60 BPM
+			"\x00\xf0\x03\x7e\x7f\xf7"+
+			"\x00\xff\x05\x02la"+
+			"\x00\xff\x2f\x00")
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, _ := captureOutput(func() {
+		if code := playMidiFile(file, &noColorizer{}, evutil.NewCombinedSelector()); code != 0 {
+			t.Errorf("exit code = %d", code)
+		}
+	})
+	want := []string{
+		file + "#0 [v0000 p0000]:\tTempo",
+		file + "#1 [v0000 p0000]:\tDrums",
+		"# From device [v0000 p0000]: Tempo (" + file + "#0)",
+		"[0.000000] Meta: TrackName - \"Tempo\"",
+		"[0.000000] Meta: Tempo - 120.00 BPM (500000 us per quarter note)",
+		"[0.000000] Meta: TimeSignature - 3/4, 24 MIDI clocks per click, 8 32nd notes per quarter note",
+		"[0.000000] Meta: KeySignature - C minor",
+		"# From device [v0000 p0000]: Drums (" + file + "#1)",
+		"[0.000000] Meta: TrackName - \"Drums\"",
+		"[0.000000] MIDI: Note On (Ch 10) - Note 36 (C2 / Bass Drum 1), Velocity 100",
+		"# From device [v0000 p0000]: Tempo (" + file + "#0)",
+		"[0.500000] Meta: Tempo - 60.00 BPM (1000000 us per quarter note)",
+		"# From device [v0000 p0000]: Drums (" + file + "#1)",
+		"[0.500000] MIDI: Note On (Ch 10) - Note 38 (D2 / Acoustic Snare), Velocity 80",
+		"[1.500000] MIDI: Control Change (Ch 10) - Controller 7 (Main Volume), Value 100",
+		"[1.500000] MIDI: SysEx - Length 4 bytes, Bytes: f0 7e 7f f7",
+		"[1.500000] Meta: Lyric - \"la\"",
+	}
+	got := strings.Split(strings.TrimSpace(stdout), "\n")
+	for i := range got {
+		got[i] = strings.TrimSpace(strings.Join(strings.Fields(got[i]), " "))
+	}
+	for i := range want {
+		want[i] = strings.Join(strings.Fields(want[i]), " ")
+	}
+	if !slices.Equal(got, want) || stderr != "" {
+		t.Errorf("got\n%s\nwant\n%s\nstderr: %s", strings.Join(got, "\n"), strings.Join(want, "\n"), stderr)
+	}
+
+	// A recording can be played back.
+	rec := filepath.Join(t.TempDir(), "take1.mid")
+	m, err := newMidiRecorder(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.track(&MidiDevice{path: "/dev/snd/midiC1D0", name: "Keys"}).record(
+		MidiEvent{Timestamp: time.Unix(1712345678, 0), Status: 0x90, Data1: 60, Data2: 100, Type: "NoteOn"})
+	if err := m.close(); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, _ = captureOutput(func() {
+		playMidiFile(rec, &noColorizer{}, evutil.NewCombinedSelector().Add(evutil.NewReSelector("keys")))
+	})
+	if !strings.Contains(stdout, "Note On (Ch 1) - Note 60 (C4), Velocity 100") || strings.Contains(stdout, "evsniff") {
+		t.Errorf("got %s", stdout)
+	}
+
+	for _, bad := range []string{"", "MThd\x00\x00\x00\x06\x00\x01\x00\x01\x00\x00", "MThd\x00\x00\x00\x06\x00\x00\x00\x01\x01\xe0" + track("\x00\x40\x40")} {
+		if _, err := readSmf(strings.NewReader(bad)); err == nil {
+			t.Errorf("readSmf(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+// Per-device key, LED, switch and absolute axis state, used for the modifier fields in
+// simple mode and for resynchronizing after SYN_DROPPED.
+//
+// When the kernel's event buffer overflows, it queues SYN_DROPPED and throws away events.
+// Following the procedure in the kernel's Documentation/input/event-codes.rst, we then
+// discard everything up to the next SYN_REPORT, re-query the state with EVIOCG*,
+// and emit synthetic events for whatever changed in the meantime.
+
+import (
+	"slices"
+	"syscall"
+	"unsafe"
+
+	"github.com/holoplot/go-evdev"
+)
+
+var (
+	evioCGKey = ioc(iocRead, 'E', 0x18, evdev.KEY_MAX/8+1)
+	evioCGLed = ioc(iocRead, 'E', 0x19, evdev.LED_MAX/8+1)
+	evioCGSw  = ioc(iocRead, 'E', 0x1b, evdev.SW_MAX/8+1)
+)
+
+func evioCGAbs(code evdev.EvCode) uint32 {
+	return ioc(iocRead, 'E', 0x40+uint32(code), uint32(unsafe.Sizeof(evdev.AbsInfo{})))
+}
+
+type deviceState struct {
+	keys map[evdev.EvCode]int32
+	leds map[evdev.EvCode]int32
+	sws  map[evdev.EvCode]int32
+	abs  map[evdev.EvCode]int32
+}
+
+func newDeviceState() *deviceState {
+	return &deviceState{
+		keys: make(map[evdev.EvCode]int32),
+		leds: make(map[evdev.EvCode]int32),
+		sws:  make(map[evdev.EvCode]int32),
+		abs:  make(map[evdev.EvCode]int32),
+	}
+}
+
+// path -> state
+var deviceStates = make(map[string]*deviceState)
+
+func getDeviceState(path string) *deviceState {
+	s := deviceStates[path]
+	if s == nil {
+		s = newDeviceState()
+		deviceStates[path] = s
+	}
+	return s
+}
+
+// values returns the state map for the event type t, or nil if t isn't tracked.
+func (s *deviceState) values(t evdev.EvType) map[evdev.EvCode]int32 {
+	switch t {
+	case evdev.EV_KEY:
+		return s.keys
+	case evdev.EV_LED:
+		return s.leds
+	case evdev.EV_SW:
+		return s.sws
+	case evdev.EV_ABS:
+		return s.abs
+	}
+	return nil
+}
+
+// updateDeviceState records the value of e, if it's a tracked event type.
+func updateDeviceState(path string, e *evdev.InputEvent) {
+	if e.Type == evdev.EV_ABS && e.Code >= evdev.ABS_MT_SLOT {
+		return // Multitouch values are per slot; see queryDeviceState.
+	}
+	if m := getDeviceState(path).values(e.Type); m != nil {
+		m[e.Code] = e.Value
+	}
+}
+
+func queryBits(fd int, code uint32, size int) ([]byte, error) {
+	bits := make([]byte, size)
+	if err := doRawIoctl(uintptr(fd), code, unsafe.Pointer(&bits[0])); err != nil {
+		return nil, err
+	}
+	return bits, nil
+}
+
+func setBitsToState(m map[evdev.EvCode]int32, bits []byte) {
+	for _, b := range bitmaskBits(bits) {
+		m[evdev.EvCode(b)] = 1
+	}
+}
+
+// queryDeviceState reads the current state of a device from the kernel.
+// absCodes are the axes to query. Multitouch axes are skipped, because EVIOCGABS only
+// returns the values of the current slot.
+func queryDeviceState(fd int, absCodes []evdev.EvCode) (*deviceState, error) {
+	s := newDeviceState()
+
+	keys, err := queryBits(fd, evioCGKey, evdev.KEY_MAX/8+1)
+	if err != nil {
+		return nil, err
+	}
+	setBitsToState(s.keys, keys)
+
+	// Devices without LEDs or switches fail these with EINVAL on some kernels.
+	if leds, err := queryBits(fd, evioCGLed, evdev.LED_MAX/8+1); err == nil {
+		setBitsToState(s.leds, leds)
+	}
+	if sws, err := queryBits(fd, evioCGSw, evdev.SW_MAX/8+1); err == nil {
+		setBitsToState(s.sws, sws)
+	}
+
+	for _, code := range absCodes {
+		if code >= evdev.ABS_MT_SLOT {
+			continue
+		}
+		var info evdev.AbsInfo
+		if err := doRawIoctl(uintptr(fd), evioCGAbs(code), unsafe.Pointer(&info)); err != nil {
+			return nil, err
+		}
+		s.abs[code] = info.Value
+	}
+	return s, nil
+}
+
+// stateDelta returns the events that bring old up to date with cur, followed by a SYN_REPORT,
+// or nil if nothing changed. Keys only count as changed when they go from up to down
+// or vice versa; the kernel doesn't report autorepeat state.
+func stateDelta(old, cur *deviceState, tv syscall.Timeval) []evdev.InputEvent {
+	var ret []evdev.InputEvent
+
+	for _, t := range []evdev.EvType{evdev.EV_KEY, evdev.EV_SW, evdev.EV_LED, evdev.EV_ABS} {
+		oldValues := old.values(t)
+		curValues := cur.values(t)
+
+		codes := make([]evdev.EvCode, 0)
+		for code := range curValues {
+			codes = append(codes, code)
+		}
+		if t != evdev.EV_ABS {
+			// A missing key, LED or switch is off. Missing axes just weren't queried.
+			for code := range oldValues {
+				if _, ok := curValues[code]; !ok {
+					codes = append(codes, code)
+				}
+			}
+		}
+		slices.Sort(codes)
+
+		for _, code := range codes {
+			o := oldValues[code]
+			c := curValues[code]
+			changed := o != c
+			if t == evdev.EV_KEY {
+				changed = (o > 0) != (c > 0)
+			}
+			if changed {
+				ret = append(ret, evdev.InputEvent{Time: tv, Type: t, Code: code, Value: c})
+			}
+		}
+	}
+
+	if len(ret) > 0 {
+		ret = append(ret, evdev.InputEvent{Time: tv, Type: evdev.EV_SYN, Code: evdev.SYN_REPORT})
+	}
+	return ret
+}
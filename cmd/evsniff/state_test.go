@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestStateDelta(t *testing.T) {
+	tv := syscall.NsecToTimeval(1_500_000_000)
+
+	old := newDeviceState()
+	old.keys[evdev.KEY_LEFTSHIFT] = 1
+	old.keys[evdev.KEY_A] = 2 // Autorepeat
+	old.leds[evdev.LED_CAPSL] = 1
+	old.abs[evdev.ABS_X] = 100
+	old.abs[evdev.ABS_Y] = 200
+
+	cur := newDeviceState()
+	cur.keys[evdev.KEY_A] = 1
+	cur.keys[evdev.KEY_LEFTCTRL] = 1
+	cur.sws[evdev.SW_LID] = 1
+	cur.abs[evdev.ABS_X] = 150
+
+	want := []evdev.InputEvent{
+		{Time: tv, Type: evdev.EV_KEY, Code: evdev.KEY_LEFTCTRL, Value: 1},
+		{Time: tv, Type: evdev.EV_KEY, Code: evdev.KEY_LEFTSHIFT, Value: 0},
+		{Time: tv, Type: evdev.EV_SW, Code: evdev.SW_LID, Value: 1},
+		{Time: tv, Type: evdev.EV_LED, Code: evdev.LED_CAPSL, Value: 0},
+		{Time: tv, Type: evdev.EV_ABS, Code: evdev.ABS_X, Value: 150},
+		{Time: tv, Type: evdev.EV_SYN, Code: evdev.SYN_REPORT},
+	}
+	if got := stateDelta(old, cur, tv); !slices.Equal(got, want) {
+		t.Errorf("stateDelta() =\n%v\nwant\n%v", got, want)
+	}
+
+	if got := stateDelta(cur, cur, tv); got != nil {
+		t.Errorf("stateDelta() with no changes = %v, want nil", got)
+	}
+}
+
+func TestUpdateDeviceState(t *testing.T) {
+	path := "/dev/input/test-state"
+	defer delete(deviceStates, path)
+
+	updateDeviceState(path, &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_RIGHTALT, Value: 1})
+	updateDeviceState(path, &evdev.InputEvent{Type: evdev.EV_ABS, Code: evdev.ABS_MT_POSITION_X, Value: 5})
+	updateDeviceState(path, &evdev.InputEvent{Type: evdev.EV_REL, Code: evdev.REL_X, Value: 5})
+
+	if got := scopeKeyState([]string{path}, evdev.KEY_LEFTALT, evdev.KEY_RIGHTALT); got != 1 {
+		t.Errorf("alt state = %d, want 1", got)
+	}
+	if _, ok := getDeviceState(path).abs[evdev.ABS_MT_POSITION_X]; ok {
+		t.Errorf("multitouch axis was tracked")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+// Device discovery from sysfs. /sys/class/input/eventN/device describes each input device
+// (name, IDs, capability bitmaps, properties) and is readable by everyone, so devices can be
+// listed and matched against the FILTERs without opening the device nodes, which usually
+// requires root or membership in the input group.
+//
+// sysfs doesn't have the current state and the absinfo, so those are read from the device
+// node, when it can be opened.
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/omakoto/evsniff-go/evutil"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/maruel/natural"
+	"github.com/omakoto/go-common/src/utils"
+)
+
+var sysfsInputPath = "/sys/class/input"
+
+// capabilityFiles are the files in capabilities/ for each event type.
+var capabilityFiles = map[evdev.EvType]string{
+	evdev.EV_KEY: "key",
+	evdev.EV_REL: "rel",
+	evdev.EV_ABS: "abs",
+	evdev.EV_MSC: "msc",
+	evdev.EV_SW:  "sw",
+	evdev.EV_LED: "led",
+	evdev.EV_SND: "snd",
+	evdev.EV_FF:  "ff",
+}
+
+// sysfsDevice is an input device described by sysfs.
+type sysfsDevice struct {
+	path    string // /dev/input/eventN
+	sysPath string // /sys/class/input/eventN/device
+	name    string
+	phys    string
+	uniq    string
+	id      evdev.InputID
+	types   []byte
+	codes   map[evdev.EvType][]byte
+	props   []byte
+
+	udevProps   map[string]string
+	udevGuessed bool // udevProps came from classifyDevice.
+	aliases     []string
+
+	node    *evdev.InputDevice // Opened on demand for State and AbsInfos.
+	nodeErr error
+}
+
+var _ inputDevice = (*sysfsDevice)(nil)
+
+func readSysfsString(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(data), "\n")
+}
+
+func readSysfsHex(dir, name string) uint16 {
+	v, _ := strconv.ParseUint(readSysfsString(dir, name), 16, 16)
+	return uint16(v)
+}
+
+// parseSysfsBitmap parses a bitmap in sysfs, which is a list of hex "long"s, most significant first,
+// e.g. "120013" or "3 0 0 fffffffffffffffe", into a byte bitmask.
+func parseSysfsBitmap(s string) ([]byte, error) {
+	words := strings.Fields(s)
+	ret := make([]byte, len(words)*bits.UintSize/8)
+	for i, w := range words {
+		v, err := strconv.ParseUint(w, 16, bits.UintSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bitmap %q", s)
+		}
+		base := (len(words) - 1 - i) * bits.UintSize / 8
+		for b := 0; b < bits.UintSize/8; b++ {
+			ret[base+b] = byte(v >> (b * 8))
+		}
+	}
+	return ret, nil
+}
+
+func readSysfsBitmap(dir, name string) []byte {
+	ret, err := parseSysfsBitmap(readSysfsString(dir, name))
+	if err != nil {
+		return nil
+	}
+	return ret
+}
+
+// newSysfsDevice reads the description of a /dev/input/eventN device from sysfs.
+func newSysfsDevice(path string) (*sysfsDevice, error) {
+	sysPath := filepath.Join(sysfsInputPath, filepath.Base(path), "device")
+	if _, err := os.Stat(filepath.Join(sysPath, "name")); err != nil {
+		return nil, err
+	}
+	d := &sysfsDevice{
+		path:    path,
+		sysPath: sysPath,
+		name:    readSysfsString(sysPath, "name"),
+		phys:    readSysfsString(sysPath, "phys"),
+		uniq:    readSysfsString(sysPath, "uniq"),
+		id: evdev.InputID{
+			BusType: readSysfsHex(sysPath, "id/bustype"),
+			Vendor:  readSysfsHex(sysPath, "id/vendor"),
+			Product: readSysfsHex(sysPath, "id/product"),
+			Version: readSysfsHex(sysPath, "id/version"),
+		},
+		types: readSysfsBitmap(sysPath, "capabilities/ev"),
+		codes: make(map[evdev.EvType][]byte),
+		props: readSysfsBitmap(sysPath, "properties"),
+	}
+	for t, file := range capabilityFiles {
+		if b := readSysfsBitmap(sysPath, "capabilities/"+file); b != nil {
+			d.codes[t] = b
+		}
+	}
+	return d, nil
+}
+
+// matchableDevice returns the device to match FILTERs against when only the path and the name of
+// a device are at hand: the sysfs device, which also has the IDs, classes and udev properties, or
+// just the path and the name if sysfs doesn't have it with that name, e.g. in a recording.
+func matchableDevice(path, name string) evutil.Device {
+	if sd, err := newSysfsDevice(path); err == nil && sd.name == name {
+		return sd
+	}
+	return &rawDevice{path: path, name: name}
+}
+
+// listSysfsDevices returns all the evdev devices in sysfs, sorted by path.
+func listSysfsDevices() ([]*sysfsDevice, error) {
+	entries, err := os.ReadDir(sysfsInputPath)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "event") {
+			paths = append(paths, devInput+"/"+e.Name())
+		}
+	}
+	slices.SortFunc(paths, utils.LessToCmp(natural.Less))
+
+	ret := make([]*sysfsDevice, 0, len(paths))
+	for _, path := range paths {
+		d, err := newSysfsDevice(path)
+		if err != nil {
+			continue // Removed in the meantime.
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+func (d *sysfsDevice) Path() string {
+	return d.path
+}
+
+func (d *sysfsDevice) Name() (string, error) {
+	return d.name, nil
+}
+
+func (d *sysfsDevice) PhysicalLocation() (string, error) {
+	return d.phys, nil
+}
+
+func (d *sysfsDevice) UniqueID() (string, error) {
+	return d.uniq, nil
+}
+
+func (d *sysfsDevice) InputID() (evdev.InputID, error) {
+	return d.id, nil
+}
+
+func (d *sysfsDevice) CapableTypes() []evdev.EvType {
+	ret := make([]evdev.EvType, 0)
+	for _, b := range bitmaskBits(d.types) {
+		ret = append(ret, evdev.EvType(b))
+	}
+	return ret
+}
+
+func (d *sysfsDevice) CapableEvents(t evdev.EvType) []evdev.EvCode {
+	ret := make([]evdev.EvCode, 0)
+	for _, b := range bitmaskBits(d.codes[t]) {
+		ret = append(ret, evdev.EvCode(b))
+	}
+	return ret
+}
+
+func (d *sysfsDevice) Properties() []evdev.EvProp {
+	ret := make([]evdev.EvProp, 0)
+	for _, b := range bitmaskBits(d.props) {
+		ret = append(ret, evdev.EvProp(b))
+	}
+	return ret
+}
+
+// openNode opens the device node, for what sysfs doesn't have.
+func (d *sysfsDevice) openNode() (*evdev.InputDevice, error) {
+	if d.node == nil && d.nodeErr == nil {
+		d.node, d.nodeErr = evdev.Open(d.path)
+	}
+	return d.node, d.nodeErr
+}
+
+func (d *sysfsDevice) State(t evdev.EvType) (evdev.StateMap, error) {
+	node, err := d.openNode()
+	if err != nil {
+		return nil, err
+	}
+	return node.State(t)
+}
+
+func (d *sysfsDevice) AbsInfos() (map[evdev.EvCode]evdev.AbsInfo, error) {
+	node, err := d.openNode()
+	if err != nil {
+		return nil, err
+	}
+	return node.AbsInfos()
+}
+
+// close closes the device node, if it was opened.
+func (d *sysfsDevice) close() {
+	if d.node != nil {
+		d.node.Close()
+		d.node = nil
+	}
+}
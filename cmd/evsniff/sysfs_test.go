@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestParseSysfsBitmap(t *testing.T) {
+	got, err := parseSysfsBitmap("120013")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 4, 17, 20}; !slices.Equal(bitmaskBits(got), want) {
+		t.Errorf("bits = %v, want %v", bitmaskBits(got), want)
+	}
+
+	got, err = parseSysfsBitmap("3 0 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, bits.UintSize * 2, bits.UintSize*2 + 1}; !slices.Equal(bitmaskBits(got), want) {
+		t.Errorf("bits = %v, want %v", bitmaskBits(got), want)
+	}
+
+	if _, err := parseSysfsBitmap("3 xyz"); err == nil {
+		t.Errorf("parseSysfsBitmap() succeeded with an invalid word")
+	}
+}
+
+func writeSysfsFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListSysfsDevices(t *testing.T) {
+	orig := sysfsInputPath
+	defer func() { sysfsInputPath = orig }()
+	sysfsInputPath = t.TempDir()
+
+	writeSysfsFiles(t, filepath.Join(sysfsInputPath, "event10", "device"), map[string]string{
+		"name":             "Logitech USB Keyboard",
+		"phys":             "usb-0000:00:14.0-2/input0",
+		"uniq":             "",
+		"id/bustype":       "0003",
+		"id/vendor":        "046d",
+		"id/product":       "c31c",
+		"id/version":       "0110",
+		"capabilities/ev":  "120013",
+		"capabilities/key": "1e 0",
+		"capabilities/led": "7",
+		"capabilities/rel": "0",
+		"properties":       "0",
+		"capabilities/abs": "0",
+		"capabilities/msc": "10",
+		"capabilities/sw":  "0",
+		"capabilities/snd": "0",
+		"capabilities/ff":  "0",
+	})
+	writeSysfsFiles(t, filepath.Join(sysfsInputPath, "event2", "device"), map[string]string{
+		"name":       "Power Button",
+		"id/vendor":  "0000",
+		"id/product": "0001",
+	})
+	writeSysfsFiles(t, filepath.Join(sysfsInputPath, "mouse0", "device"), map[string]string{
+		"name": "mousedev",
+	})
+
+	devs, err := listSysfsDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devs) != 2 || devs[0].Path() != "/dev/input/event2" || devs[1].Path() != "/dev/input/event10" {
+		t.Fatalf("devices = %v", devs)
+	}
+
+	d := devs[1]
+	id, _ := d.InputID()
+	if want := (evdev.InputID{BusType: 3, Vendor: 0x046d, Product: 0xc31c, Version: 0x110}); id != want {
+		t.Errorf("InputID() = %+v, want %+v", id, want)
+	}
+	if phys, _ := d.PhysicalLocation(); phys != "usb-0000:00:14.0-2/input0" {
+		t.Errorf("PhysicalLocation() = %q", phys)
+	}
+	if want := []evdev.EvType{evdev.EV_SYN, evdev.EV_KEY, evdev.EV_MSC, evdev.EV_LED, evdev.EV_REP}; !slices.Equal(d.CapableTypes(), want) {
+		t.Errorf("CapableTypes() = %v, want %v", d.CapableTypes(), want)
+	}
+	wantKeys := []evdev.EvCode{
+		evdev.EvCode(bits.UintSize + 1), evdev.EvCode(bits.UintSize + 2),
+		evdev.EvCode(bits.UintSize + 3), evdev.EvCode(bits.UintSize + 4),
+	}
+	if got := d.CapableEvents(evdev.EV_KEY); !slices.Equal(got, wantKeys) {
+		t.Errorf("CapableEvents(EV_KEY) = %v, want %v", got, wantKeys)
+	}
+	if got := d.CapableEvents(evdev.EV_MSC); !slices.Equal(got, []evdev.EvCode{evdev.MSC_SCAN}) {
+		t.Errorf("CapableEvents(EV_MSC) = %v", got)
+	}
+	if len(d.Properties()) != 0 {
+		t.Errorf("Properties() = %v", d.Properties())
+	}
+}
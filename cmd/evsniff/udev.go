@@ -0,0 +1,248 @@
+package main
+
+// Device classification with the udev database. udev's input_id builtin tags each input device
+// with ID_INPUT_KEYBOARD, ID_INPUT_TOUCHPAD, etc., and stores the properties in
+// /run/udev/data/c13:MINOR, which is readable by everyone.
+//
+// Without the udev database (e.g. in containers or on Android), classifyDevice guesses the same
+// ID_INPUT_* properties from the capability bits, with the same rules as input_id.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+	"github.com/omakoto/go-common/src/utils"
+)
+
+var udevDataPath = "/run/udev/data"
+
+// deviceClasses are the device classes that can be selected with @CLASS.
+var deviceClasses = []string{
+	"keyboard", "key", "mouse", "touchpad", "touchscreen", "tablet", "tablet_pad",
+	"joystick", "accelerometer", "pointingstick", "switch",
+}
+
+// readUdevProperties reads the "E:" lines of a udev database file.
+func readUdevProperties(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "E:")
+		if !ok {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			ret[key] = value
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// udevDataFile returns the udev database file of /dev/input/eventN.
+func udevDataFile(path string) string {
+	base := filepath.Base(path)
+	dev := readSysfsString(filepath.Join(sysfsInputPath, base), "dev")
+	if dev == "" {
+		// The minor numbers of evdev nodes start at 64.
+		n, _ := strconv.Atoi(strings.TrimPrefix(base, "event"))
+		dev = fmt.Sprintf("13:%d", 64+n)
+	}
+	return filepath.Join(udevDataPath, "c"+dev)
+}
+
+// classifyDevice returns the ID_INPUT_* properties for d, following udev's input_id.
+func classifyDevice(d inputDevice) map[string]string {
+	hasType := func(t evdev.EvType) bool {
+		return slices.Contains(d.CapableTypes(), t)
+	}
+	has := func(t evdev.EvType, codes ...evdev.EvCode) bool {
+		caps := d.CapableEvents(t)
+		for _, c := range codes {
+			if !slices.Contains(caps, c) {
+				return false
+			}
+		}
+		return true
+	}
+	hasAny := func(t evdev.EvType, from, to evdev.EvCode) bool {
+		return slices.ContainsFunc(d.CapableEvents(t), func(c evdev.EvCode) bool {
+			return c >= from && c <= to
+		})
+	}
+	hasProp := func(p evdev.EvProp) bool {
+		return slices.Contains(d.Properties(), p)
+	}
+
+	ret := map[string]string{"ID_INPUT": "1"}
+	set := func(class string) {
+		ret["ID_INPUT_"+strings.ToUpper(class)] = "1"
+	}
+
+	hasAbs := has(evdev.EV_ABS, evdev.ABS_X, evdev.ABS_Y)
+	if hasProp(evdev.INPUT_PROP_ACCELEROMETER) || (!hasType(evdev.EV_KEY) && hasAbs && has(evdev.EV_ABS, evdev.ABS_Z)) {
+		set("accelerometer")
+		return ret // Nothing else, like input_id.
+	}
+
+	// Pointers
+	isDirect := hasProp(evdev.INPUT_PROP_DIRECT)
+	hasRel := hasType(evdev.EV_REL) && has(evdev.EV_REL, evdev.REL_X, evdev.REL_Y)
+	hasMt := has(evdev.EV_ABS, evdev.ABS_MT_POSITION_X, evdev.ABS_MT_POSITION_Y) &&
+		// Devices that claim to have all the axes don't really have multitouch.
+		!has(evdev.EV_ABS, evdev.ABS_MT_SLOT-1, evdev.ABS_MT_SLOT)
+	hasPen := has(evdev.EV_KEY, evdev.BTN_TOOL_PEN) || has(evdev.EV_KEY, evdev.BTN_STYLUS)
+	fingerButNoPen := has(evdev.EV_KEY, evdev.BTN_TOOL_FINGER) && !has(evdev.EV_KEY, evdev.BTN_TOOL_PEN)
+	hasMouseButton := hasAny(evdev.EV_KEY, evdev.BTN_MOUSE, evdev.BTN_JOYSTICK-1)
+	hasTouch := has(evdev.EV_KEY, evdev.BTN_TOUCH)
+	hasPadButtons := has(evdev.EV_KEY, evdev.BTN_0, evdev.BTN_1) && !hasPen
+	hasWheel := hasType(evdev.EV_REL) && (has(evdev.EV_REL, evdev.REL_WHEEL) || has(evdev.EV_REL, evdev.REL_HWHEEL))
+	hasJoystick := hasAny(evdev.EV_KEY, evdev.BTN_JOYSTICK, evdev.BTN_DIGI-1) ||
+		hasAny(evdev.EV_KEY, evdev.BTN_TRIGGER_HAPPY1, evdev.BTN_TRIGGER_HAPPY40) ||
+		hasAny(evdev.EV_ABS, evdev.ABS_RX, evdev.ABS_PRESSURE-1)
+
+	var isTablet, isTabletPad, isTouchpad, isTouchscreen, isMouse, isJoystick bool
+	if hasAbs {
+		if hasPen {
+			isTablet = true
+		} else if fingerButNoPen && !isDirect {
+			isTouchpad = true
+		} else if hasMouseButton {
+			isMouse = true // E.g. VM tablets, which have absolute axes but no touch.
+		} else if hasTouch || isDirect {
+			isTouchscreen = true
+		} else if hasJoystick {
+			isJoystick = true
+		}
+	} else if hasJoystick {
+		isJoystick = true
+	}
+	if hasMt {
+		if hasPen {
+			isTablet = true
+		} else if fingerButNoPen && !isDirect {
+			isTouchpad = true
+		} else if hasTouch || isDirect {
+			isTouchscreen = true
+		}
+	}
+	if isTablet && hasPadButtons {
+		isTabletPad = true
+	}
+	if hasPadButtons && hasWheel && !hasRel {
+		isTablet = true
+		isTabletPad = true
+	}
+	if !isTablet && !isTouchpad && !isJoystick && hasMouseButton && (hasRel || !hasAbs) {
+		isMouse = true
+	}
+
+	for class, b := range map[string]bool{
+		"tablet":        isTablet,
+		"tablet_pad":    isTabletPad,
+		"touchpad":      isTouchpad,
+		"touchscreen":   isTouchscreen,
+		"mouse":         isMouse,
+		"joystick":      isJoystick,
+		"pointingstick": hasProp(evdev.INPUT_PROP_POINTING_STICK),
+	} {
+		if b {
+			set(class)
+		}
+	}
+
+	// Keys. Any key below BTN_MISC is a key; all of KEY_ESC to KEY_S is a keyboard.
+	if hasAny(evdev.EV_KEY, 1, evdev.BTN_MISC-1) || hasAny(evdev.EV_KEY, evdev.KEY_OK, evdev.BTN_TRIGGER_HAPPY-1) {
+		set("key")
+	}
+	keyboard := true
+	for c := evdev.EvCode(evdev.KEY_ESC); c <= evdev.KEY_S; c++ {
+		if !has(evdev.EV_KEY, c) {
+			keyboard = false
+			break
+		}
+	}
+	if keyboard {
+		set("keyboard")
+	}
+
+	if hasType(evdev.EV_SW) {
+		set("switch")
+	}
+	return ret
+}
+
+var _ evutil.UdevDevice = (*sysfsDevice)(nil)
+
+// UdevProperties returns the properties in the udev database, or the classifyDevice
+// properties if there's no udev data.
+func (d *sysfsDevice) UdevProperties() map[string]string {
+	if d.udevProps == nil {
+		props, err := readUdevProperties(udevDataFile(d.path))
+		if err != nil || props["ID_INPUT"] == "" {
+			props = classifyDevice(d)
+			d.udevGuessed = true
+		}
+		d.udevProps = props
+	}
+	return d.udevProps
+}
+
+// classesOf returns the classes of a device from its ID_INPUT_* properties.
+func classesOf(props map[string]string) []string {
+	ret := make([]string, 0)
+	for _, c := range deviceClasses {
+		if props["ID_INPUT_"+strings.ToUpper(c)] == "1" {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// nodeDevice is an opened device, with the classifyDevice properties, for when there's no sysfs.
+type nodeDevice struct {
+	*evdev.InputDevice
+	props   map[string]string
+	aliases []string
+}
+
+var _ evutil.UdevDevice = (*nodeDevice)(nil)
+
+func newNodeDevice(d *evdev.InputDevice) *nodeDevice {
+	return &nodeDevice{InputDevice: d}
+}
+
+func (d *nodeDevice) UdevProperties() map[string]string {
+	if d.props == nil {
+		d.props = classifyDevice(d)
+	}
+	return d.props
+}
+
+func dumpUdevProperties(d evutil.UdevDevice, prefix string) {
+	props := d.UdevProperties()
+	source := "udev"
+	if sd, ok := d.(*sysfsDevice); !ok || sd.udevGuessed {
+		source = "guessed from capabilities"
+	}
+	fmt.Printf("%sClasses: %s (%s)\n", prefix, strings.Join(classesOf(props), " "), source)
+	if source != "udev" {
+		return
+	}
+	fmt.Printf("%sUdev properties:\n", prefix)
+	for key, value := range utils.SortedMap(props) {
+		fmt.Printf("%s  %s=%s\n", prefix, key, value)
+	}
+}
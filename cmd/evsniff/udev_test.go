@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+func newTestDevice(path string, codes map[evdev.EvType][]evdev.EvCode, props ...evdev.EvProp) *sysfsDevice {
+	types := []evdev.EvType{evdev.EV_SYN}
+	d := &sysfsDevice{path: path, name: path, codes: make(map[evdev.EvType][]byte)}
+	for t, c := range codes {
+		types = append(types, t)
+		d.codes[t] = makeBitmask(c, maxCodes[t])
+	}
+	d.types = makeBitmask(types, evdev.EV_MAX)
+	d.props = makeBitmask(props, evdev.INPUT_PROP_MAX)
+	return d
+}
+
+func keyRange(from, to evdev.EvCode) []evdev.EvCode {
+	ret := make([]evdev.EvCode, 0)
+	for c := from; c <= to; c++ {
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func TestClassifyDevice(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  *sysfsDevice
+		want []string
+	}{
+		{"keyboard", newTestDevice("kbd", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_KEY: keyRange(evdev.KEY_ESC, evdev.KEY_KPDOT),
+			evdev.EV_LED: {evdev.LED_NUML, evdev.LED_CAPSL},
+		}), []string{"keyboard", "key"}},
+		{"power button", newTestDevice("power", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_KEY: {evdev.KEY_POWER},
+		}), []string{"key"}},
+		{"mouse", newTestDevice("mouse", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_KEY: {evdev.BTN_LEFT, evdev.BTN_RIGHT, evdev.BTN_MIDDLE},
+			evdev.EV_REL: {evdev.REL_X, evdev.REL_Y, evdev.REL_WHEEL},
+		}), []string{"mouse"}},
+		{"touchpad", newTestDevice("touchpad", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_KEY: {evdev.BTN_LEFT, evdev.BTN_TOOL_FINGER, evdev.BTN_TOUCH, evdev.BTN_TOOL_DOUBLETAP},
+			evdev.EV_ABS: {evdev.ABS_X, evdev.ABS_Y, evdev.ABS_MT_SLOT, evdev.ABS_MT_POSITION_X, evdev.ABS_MT_POSITION_Y},
+		}, evdev.INPUT_PROP_POINTER, evdev.INPUT_PROP_BUTTONPAD), []string{"touchpad"}},
+		{"touchscreen", newTestDevice("touchscreen", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_KEY: {evdev.BTN_TOUCH},
+			evdev.EV_ABS: {evdev.ABS_X, evdev.ABS_Y, evdev.ABS_MT_POSITION_X, evdev.ABS_MT_POSITION_Y},
+		}, evdev.INPUT_PROP_DIRECT), []string{"touchscreen"}},
+		{"tablet", newTestDevice("tablet", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_KEY: {evdev.BTN_TOOL_PEN, evdev.BTN_TOUCH, evdev.BTN_STYLUS},
+			evdev.EV_ABS: {evdev.ABS_X, evdev.ABS_Y, evdev.ABS_PRESSURE},
+		}), []string{"tablet"}},
+		{"gamepad", newTestDevice("gamepad", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_KEY: {evdev.BTN_SOUTH, evdev.BTN_EAST, evdev.BTN_START},
+			evdev.EV_ABS: {evdev.ABS_X, evdev.ABS_Y, evdev.ABS_RX, evdev.ABS_RY},
+		}), []string{"joystick"}},
+		{"accelerometer", newTestDevice("accel", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_ABS: {evdev.ABS_X, evdev.ABS_Y, evdev.ABS_Z},
+		}), []string{"accelerometer"}},
+		{"lid switch", newTestDevice("lid", map[evdev.EvType][]evdev.EvCode{
+			evdev.EV_SW: {evdev.SW_LID},
+		}), []string{"switch"}},
+	}
+	for _, tt := range tests {
+		if got := classesOf(classifyDevice(tt.dev)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: classes = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUdevSelectors(t *testing.T) {
+	origSysfs, origUdev := sysfsInputPath, udevDataPath
+	defer func() { sysfsInputPath, udevDataPath = origSysfs, origUdev }()
+	sysfsInputPath = t.TempDir()
+	udevDataPath = t.TempDir()
+
+	writeSysfsFiles(t, filepath.Join(sysfsInputPath, "event3"), map[string]string{"dev": "13:67"})
+	data := "I:123456\nE:ID_INPUT=1\nE:ID_INPUT_KEYBOARD=1\nE:ID_SEAT=seat1\nE:ID_PATH=pci-0000:00:14.0-usb-0:2:1.0\nG:seat\n"
+	if err := os.WriteFile(filepath.Join(udevDataPath, "c13:67"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The udev data wins over the classifier, which would say "mouse".
+	udevDev := newTestDevice("/dev/input/event3", map[evdev.EvType][]evdev.EvCode{
+		evdev.EV_KEY: {evdev.BTN_LEFT},
+		evdev.EV_REL: {evdev.REL_X, evdev.REL_Y},
+	})
+	// No udev data for event4.
+	guessedDev := newTestDevice("/dev/input/event4", map[evdev.EvType][]evdev.EvCode{
+		evdev.EV_KEY: {evdev.BTN_LEFT},
+		evdev.EV_REL: {evdev.REL_X, evdev.REL_Y},
+	})
+
+	tests := []struct {
+		filter            string
+		udevDev, guessDev bool
+	}{
+		{"@keyboard", true, false},
+		{"@Mouse", false, true},
+		{"@ID_SEAT=seat1", true, false},
+		{"@ID_PATH=pci-*-usb-0:2:*", true, false},
+		{"!@keyboard", false, true},
+		{"@mouse | @ID_SEAT=seat1", true, true},
+		{"@keyboard & !@ID_SEAT=seat1", false, false},
+		{"!(@keyboard & @ID_SEAT=seat1)", false, true},
+	}
+	for _, tt := range tests {
+		sel, err := parseFilter(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all := evutil.NewCombinedSelector().Add(sel)
+		if got := evutil.Matches(all, udevDev); got != tt.udevDev {
+			t.Errorf("%s on the udev device = %v, want %v", tt.filter, got, tt.udevDev)
+		}
+		if got := evutil.Matches(all, guessedDev); got != tt.guessDev {
+			t.Errorf("%s on the other device = %v, want %v", tt.filter, got, tt.guessDev)
+		}
+	}
+	if !guessedDev.udevGuessed || udevDev.udevGuessed {
+		t.Errorf("udevGuessed = %v / %v", udevDev.udevGuessed, guessedDev.udevGuessed)
+	}
+
+	for _, filter := range []string{"@keybaord", "@keyboard & @keybaord"} {
+		if _, err := parseFilter(filter); err == nil {
+			t.Errorf("parseFilter(%q) succeeded with an unknown class", filter)
+		}
+	}
+}
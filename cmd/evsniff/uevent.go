@@ -0,0 +1,201 @@
+package main
+
+// A NETLINK_KOBJECT_UEVENT listener for hotplug. Unlike inotify on /dev, uevents tell us when
+// udev has finished setting up a device node, so we don't need to guess how long to wait
+// before opening it.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	// Netlink multicast groups of uevents.
+	ueventGroupKernel = 1
+	ueventGroupUdev   = 2
+
+	// udevControlPath exists when udevd is running, in which case we listen to the udev group,
+	// whose events are sent after the rules have been applied. libudev uses the same check.
+	udevControlPath = "/run/udev/control"
+
+	// The magic number in the header of the messages from udevd.
+	udevMonitorMagic = 0xfeedcafe
+)
+
+// ueventSource receives uevents from the kernel, or from udevd if it's running.
+type ueventSource struct {
+	*hotplug
+	rawFd int
+
+	// fromUdev is true if we're listening to udevd, rather than the kernel.
+	fromUdev bool
+}
+
+var _ reactorSource = (*ueventSource)(nil)
+
+func newUeventSource(h *hotplug) (*ueventSource, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+	s := &ueventSource{hotplug: h, rawFd: fd}
+	group := uint32(ueventGroupKernel)
+	if _, err := os.Stat(udevControlPath); err == nil {
+		group = ueventGroupUdev
+		s.fromUdev = true
+	}
+	// We need the credentials to ignore uevents sent by unprivileged processes.
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1); err != nil {
+		s.close()
+		return nil, err
+	}
+	// A lot of uevents can be sent at once, e.g. when a USB hub is connected.
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1024*1024)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: group}); err != nil {
+		s.close()
+		return nil, err
+	}
+	hotplugTag = "uevent"
+	return s, nil
+}
+
+func (s *ueventSource) fd() int {
+	return s.rawFd
+}
+
+func (s *ueventSource) close() {
+	_ = syscall.Close(s.rawFd)
+}
+
+func (s *ueventSource) onReadable() error {
+	var buf [8192]byte
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofUcred))
+	for {
+		n, oobn, _, from, err := syscall.Recvmsg(s.rawFd, buf[:], oob, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return nil
+		}
+		if err == syscall.ENOBUFS {
+			// We've lost some uevents; keep going with the next ones.
+			fmt.Fprintf(os.Stderr, "Some uevents were dropped\n")
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading uevents: %v\n", err)
+			return err
+		}
+		if !s.isTrusted(oob[:oobn], from) {
+			continue
+		}
+		props, err := parseUevent(buf[:n])
+		if err != nil {
+			if *verbose {
+				fmt.Fprintf(os.Stderr, "Ignoring a uevent: %v\n", err)
+			}
+			continue
+		}
+		s.onUevent(props)
+	}
+}
+
+// isTrusted returns whether a message was sent by root, and, for the kernel group, by the kernel.
+func (s *ueventSource) isTrusted(oob []byte, from syscall.Sockaddr) bool {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil || len(msgs) == 0 {
+		return false
+	}
+	cred, err := syscall.ParseUnixCredentials(&msgs[0])
+	if err != nil || cred.Uid != 0 {
+		return false
+	}
+	if !s.fromUdev {
+		if nl, ok := from.(*syscall.SockaddrNetlink); !ok || nl.Pid != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// onUevent handles the uevents of evdev and rawmidi device nodes.
+func (s *ueventSource) onUevent(props map[string]string) {
+	path := ueventDevicePath(props)
+	if path == "" {
+		return
+	}
+	switch props["ACTION"] {
+	case "add":
+		// udevd sends the event after setting the permissions, so we can open the device right away.
+		s.added(path, s.fromUdev)
+	case "remove":
+		s.removed(path)
+	case "change":
+		s.retry(path)
+	}
+}
+
+// ueventDevicePath returns the device node path of a uevent if it's an evdev or rawmidi device.
+func ueventDevicePath(props map[string]string) string {
+	devName := props["DEVNAME"]
+	if devName == "" {
+		return ""
+	}
+	if !strings.HasPrefix(devName, "/") {
+		devName = "/dev/" + devName // The kernel sends relative names.
+	}
+	var prefix string
+	switch props["SUBSYSTEM"] {
+	case "input":
+		prefix = devInput + "/event"
+	case "sound":
+		prefix = "/dev/snd/midiC"
+	default:
+		return ""
+	}
+	if !strings.HasPrefix(devName, prefix) {
+		return ""
+	}
+	return devName
+}
+
+// parseUevent parses a uevent message, either from the kernel ("ACTION@DEVPATH\0KEY=VALUE\0...")
+// or from udevd (a "libudev" header followed by "KEY=VALUE\0...").
+func parseUevent(b []byte) (map[string]string, error) {
+	var body []byte
+	if bytes.HasPrefix(b, []byte("libudev\x00")) {
+		if len(b) < 24 {
+			return nil, errors.New("udev message too short")
+		}
+		if magic := binary.BigEndian.Uint32(b[8:12]); magic != udevMonitorMagic {
+			return nil, fmt.Errorf("invalid udev magic 0x%08x", magic)
+		}
+		off := int(binary.NativeEndian.Uint32(b[16:20]))
+		size := int(binary.NativeEndian.Uint32(b[20:24]))
+		if off < 24 || off+size > len(b) {
+			return nil, fmt.Errorf("invalid udev properties offset %d and length %d", off, size)
+		}
+		body = b[off : off+size]
+	} else {
+		i := bytes.IndexByte(b, 0)
+		if i < 0 || bytes.IndexByte(b[:i], '@') < 0 {
+			return nil, errors.New("invalid uevent header")
+		}
+		body = b[i+1:]
+	}
+
+	props := make(map[string]string)
+	for _, kv := range bytes.Split(body, []byte{0}) {
+		if k, v, ok := strings.Cut(string(kv), "="); ok {
+			props[k] = v
+		}
+	}
+	if props["ACTION"] == "" {
+		return nil, errors.New("uevent without ACTION")
+	}
+	return props, nil
+}
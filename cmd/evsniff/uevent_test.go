@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+func udevMessage(props ...string) []byte {
+	body := []byte(strings.Join(props, "\x00") + "\x00")
+	header := make([]byte, 40)
+	copy(header, "libudev\x00")
+	binary.BigEndian.PutUint32(header[8:], udevMonitorMagic)
+	binary.NativeEndian.PutUint32(header[12:], 40)
+	binary.NativeEndian.PutUint32(header[16:], 40)
+	binary.NativeEndian.PutUint32(header[20:], uint32(len(body)))
+	return append(header, body...)
+}
+
+func TestParseUevent(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want string // The device path, or "" if ignored.
+	}{
+		{"kernel", []byte("add@/devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2:1.0/input/input30/event7\x00" +
+			"ACTION=add\x00DEVPATH=/devices/.../event7\x00SUBSYSTEM=input\x00MAJOR=13\x00MINOR=71\x00DEVNAME=input/event7\x00SEQNUM=5021\x00"),
+			"/dev/input/event7"},
+		{"udev", udevMessage("ACTION=remove", "SUBSYSTEM=input", "DEVNAME=/dev/input/event7", "ID_INPUT_KEYBOARD=1"),
+			"/dev/input/event7"},
+		{"midi", udevMessage("ACTION=add", "SUBSYSTEM=sound", "DEVNAME=/dev/snd/midiC1D0"), "/dev/snd/midiC1D0"},
+		{"input parent", []byte("add@/devices/.../input30\x00ACTION=add\x00SUBSYSTEM=input\x00NAME=\"Keyboard\"\x00"), ""},
+		{"mouse node", udevMessage("ACTION=add", "SUBSYSTEM=input", "DEVNAME=/dev/input/mouse0"), ""},
+		{"pcm", udevMessage("ACTION=add", "SUBSYSTEM=sound", "DEVNAME=/dev/snd/pcmC0D0p"), ""},
+		{"usb", []byte("add@/devices/.../1-2\x00ACTION=add\x00SUBSYSTEM=usb\x00DEVNAME=bus/usb/001/005\x00"), ""},
+	}
+	for _, tt := range tests {
+		props, err := parseUevent(tt.msg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := ueventDevicePath(props); got != tt.want {
+			t.Errorf("%s: path = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	bad := udevMessage("ACTION=add")
+	binary.BigEndian.PutUint32(bad[8:], 0x12345678)
+	for _, msg := range [][]byte{nil, []byte("libudev\x00"), bad, []byte("no header"), []byte("add@/x\x00SUBSYSTEM=input\x00")} {
+		if _, err := parseUevent(msg); err == nil {
+			t.Errorf("parseUevent(%q) succeeded", msg)
+		}
+	}
+}
+
+func TestHotplugRemovalNames(t *testing.T) {
+	h := &hotplug{
+		col:     &noColorizer{},
+		pending: mapset.NewThreadUnsafeSet[string](),
+		names:   map[string]string{"/dev/input/event7": "Logitech USB Keyboard"},
+	}
+	h.pending.Add("/dev/input/event8")
+
+	stdout, _, _ := captureOutput(func() {
+		h.removed("/dev/input/event7")
+		h.removed("/dev/input/event8")
+	})
+	want := []string{
+		"[inotify] DELETE: /dev/input/event7 (Logitech USB Keyboard)",
+		"[inotify] DELETE: /dev/input/event8",
+	}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(h.names) != 0 || !h.pending.IsEmpty() {
+		t.Errorf("names = %v, pending = %v", h.names, h.pending)
+	}
+}
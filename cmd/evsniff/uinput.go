@@ -0,0 +1,179 @@
+package main
+
+// Replay of recordings into a virtual /dev/uinput device, so input bugs can be
+// reproduced deterministically. The virtual device mirrors the recorded device's
+// name, IDs, capability bits, absinfo and properties.
+//
+// go-evdev's CreateDevice can't set up absinfo (ranges, resolution), so we use the
+// UI_DEV_SETUP / UI_ABS_SETUP ioctls directly.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// struct uinput_setup
+type uinputSetup struct {
+	ID           evdev.InputID
+	Name         [80]byte
+	FFEffectsMax uint32
+}
+
+// struct uinput_abs_setup
+type uinputAbsSetup struct {
+	Code    uint16
+	_       uint16
+	AbsInfo evdev.AbsInfo
+}
+
+var (
+	uiDevCreate  = ioc(iocNone, 'U', 1, 0)
+	uiDevDestroy = ioc(iocNone, 'U', 2, 0)
+	uiDevSetup   = ioc(iocWrite, 'U', 3, uint32(unsafe.Sizeof(uinputSetup{})))
+	uiAbsSetup   = ioc(iocWrite, 'U', 4, uint32(unsafe.Sizeof(uinputAbsSetup{})))
+	uiSetEvBit   = ioc(iocWrite, 'U', 100, 4)
+	uiSetPropBit = ioc(iocWrite, 'U', 110, 4)
+)
+
+// uiSetCodeBits is the UI_SET_*BIT ioctl for each event type that can be mirrored.
+// EV_FF is left out, because it'd require implementing force feedback uploads.
+var uiSetCodeBits = map[evdev.EvType]uint32{
+	evdev.EV_KEY: ioc(iocWrite, 'U', 101, 4),
+	evdev.EV_REL: ioc(iocWrite, 'U', 102, 4),
+	evdev.EV_ABS: ioc(iocWrite, 'U', 103, 4),
+	evdev.EV_MSC: ioc(iocWrite, 'U', 104, 4),
+	evdev.EV_LED: ioc(iocWrite, 'U', 105, 4),
+	evdev.EV_SND: ioc(iocWrite, 'U', 106, 4),
+	evdev.EV_SW:  ioc(iocWrite, 'U', 109, 4),
+}
+
+type uinputDevice struct {
+	file *os.File
+}
+
+// createUinputDevice creates a virtual device with the same description as d.
+func createUinputDevice(d inputDevice) (*uinputDevice, error) {
+	f, err := os.OpenFile("/dev/uinput", os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	u := &uinputDevice{file: f}
+	if err := u.setup(d); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *uinputDevice) setup(d inputDevice) error {
+	fd := u.file.Fd()
+
+	for _, t := range d.CapableTypes() {
+		if t == evdev.EV_FF {
+			continue
+		}
+		if err := doRawIoctlValue(fd, uiSetEvBit, uintptr(t)); err != nil {
+			return fmt.Errorf("cannot set event type %s: %w", evdev.TypeName(t), err)
+		}
+		code, ok := uiSetCodeBits[t]
+		if !ok {
+			continue
+		}
+		for _, c := range d.CapableEvents(t) {
+			if err := doRawIoctlValue(fd, code, uintptr(c)); err != nil {
+				return fmt.Errorf("cannot set event code %s: %w", evdev.CodeName(t, c), err)
+			}
+		}
+	}
+	for _, p := range d.Properties() {
+		if err := doRawIoctlValue(fd, uiSetPropBit, uintptr(p)); err != nil {
+			return fmt.Errorf("cannot set property %s: %w", evdev.PropName(p), err)
+		}
+	}
+
+	absInfos, err := d.AbsInfos()
+	if err == nil {
+		for code, absInfo := range absInfos {
+			setup := uinputAbsSetup{Code: uint16(code), AbsInfo: absInfo}
+			if err := doRawIoctl(fd, uiAbsSetup, unsafe.Pointer(&setup)); err != nil {
+				return fmt.Errorf("cannot set up %s: %w", evdev.CodeName(evdev.EV_ABS, code), err)
+			}
+		}
+	}
+
+	name, err := d.Name()
+	if err != nil {
+		return err
+	}
+	id, err := d.InputID()
+	if err != nil {
+		return err
+	}
+	setup := uinputSetup{ID: id}
+	copy(setup.Name[:len(setup.Name)-1], name)
+	if err := doRawIoctl(fd, uiDevSetup, unsafe.Pointer(&setup)); err != nil {
+		return fmt.Errorf("cannot set up device: %w", err)
+	}
+	if err := doRawIoctlValue(fd, uiDevCreate, 0); err != nil {
+		return fmt.Errorf("cannot create device: %w", err)
+	}
+	return nil
+}
+
+func (u *uinputDevice) write(e *evdev.InputEvent) error {
+	return binary.Write(u.file, binary.LittleEndian, e)
+}
+
+func (u *uinputDevice) close() {
+	_ = doRawIoctlValue(u.file.Fd(), uiDevDestroy, 0)
+	_ = u.file.Close()
+}
+
+func timevalToDuration(tv syscall.Timeval) time.Duration {
+	return time.Duration(tv.Nano())
+}
+
+// replayToUinput re-emits the events of a recording through a virtual device, keeping
+// the original inter-event timing (scaled by --speed), --loop times.
+func replayToUinput(d *evemuDevice, col colorizer) int {
+	if len(d.events) == 0 {
+		fmt.Fprintf(os.Stderr, "No events in %s\n", d.path)
+		return 1
+	}
+
+	u, err := createUinputDevice(d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating uinput device: %v\n", err)
+		return 1
+	}
+	defer u.close()
+
+	// Give udev and other listeners a moment to pick up the new device.
+	time.Sleep(time.Second)
+
+	first := timevalToDuration(d.events[0].Time)
+	for i := 0; *replayLoop == 0 || i < *replayLoop; i++ {
+		start := time.Now()
+		for j := range d.events {
+			e := d.events[j]
+			if e.Type == evdev.EV_SYN && e.Code == evdev.SYN_DROPPED {
+				continue
+			}
+			offset := time.Duration(float64(timevalToDuration(e.Time)-first) / *replaySpeed)
+			time.Sleep(time.Until(start.Add(offset)))
+
+			if err := u.write(&e); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing to uinput device: %v\n", err)
+				return 1
+			}
+			handleEvent(&e, col, d.path, d.id, d.name)
+		}
+	}
+	return 0
+}
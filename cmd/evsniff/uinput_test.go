@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestUinputIoctlCodes(t *testing.T) {
+	// Values from <linux/uinput.h> on 64-bit architectures.
+	tests := []struct {
+		name string
+		got  uint32
+		want uint32
+	}{
+		{"UI_DEV_CREATE", uiDevCreate, 0x5501},
+		{"UI_DEV_DESTROY", uiDevDestroy, 0x5502},
+		{"UI_DEV_SETUP", uiDevSetup, 0x405c5503},
+		{"UI_ABS_SETUP", uiAbsSetup, 0x401c5504},
+		{"UI_SET_EVBIT", uiSetEvBit, 0x40045564},
+		{"UI_SET_PROPBIT", uiSetPropBit, 0x4004556e},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s = %#x, want %#x", tc.name, tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+// Variables for --where expressions.
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/omakoto/evsniff-go/evutil"
+)
+
+var whereVarNames = []string{
+	"kind", "path", "name", "vendor", "product",
+	"type", "code", "value",
+	"midi.type", "midi.channel", "midi.note", "midi.velocity", "midi.controller", "midi.value",
+	"midi.data1", "midi.data2",
+	"channel", "note", "velocity", "controller",
+}
+
+var whereExpr *evutil.Expr
+
+// midiTypeNames are the values of type and midi.type for MIDI events.
+var midiTypeNames = []string{
+	"NoteOff", "NoteOn", "PolyPressure", "ControlChange", "ProgramChange", "ChannelPressure", "PitchBend",
+	"SysEx", "MTCQuarterFrame", "SongPositionPointer", "SongSelect", "TuneRequest", "SysExEnd", "SystemCommon",
+	"RealTime", "Unknown",
+}
+
+// parseWhere parses a --where expression.
+func parseWhere(s string) (*evutil.Expr, error) {
+	return evutil.ParseExprWithNames(s, whereVarNames, resolveWhereName)
+}
+
+// resolveWhereName resolves the event type and code names in --where to their numbers, so that
+// all the names of a code match, e.g. BTN_LEFT, which evdev calls "BTN_MOUSE/BTN_LEFT", and
+// misspelled names are errors instead of matching nothing.
+func resolveWhereName(variable, name string) (evutil.Value, error) {
+	switch variable {
+	case "kind":
+		if name != "evdev" && name != "midi" {
+			return evutil.Value{}, fmt.Errorf("unknown kind %q; kinds are: evdev, midi", name)
+		}
+	case "type", "midi.type":
+		if t, ok := evdev.EVFromString[name]; ok && variable == "type" {
+			return evutil.NamedValue(int64(t), name), nil
+		}
+		if !slices.Contains(midiTypeNames, name) {
+			return evutil.Value{}, fmt.Errorf("unknown event type %q", name)
+		}
+	case "code":
+		for t, codes := range codeFromString {
+			if c, ok := codes[name]; ok {
+				v := evutil.NamedValue(int64(c), name)
+				v.Group = evdev.TypeName(t)
+				return v, nil
+			}
+		}
+		return evutil.Value{}, fmt.Errorf("unknown event code %q", name)
+	}
+	return evutil.Value{}, nil
+}
+
+// codeValue returns the value of code, which only equals the codes of the same event type.
+func codeValue(t evdev.EvType, c evdev.EvCode) evutil.Value {
+	v := evutil.NamedValue(int64(c), evdev.CodeName(t, c))
+	v.Group = evdev.TypeName(t)
+	return v
+}
+
+func hexValue(n uint16) evutil.Value {
+	v := evutil.NumValue(int64(n))
+	v.Str = fmt.Sprintf("%04x", n)
+	return v
+}
+
+// deviceWhereVar returns the value of the device variables.
+func deviceWhereVar(name, path, devName string, vendor, product uint16) (evutil.Value, bool) {
+	switch name {
+	case "path":
+		return evutil.StrValue(path), true
+	case "name":
+		return evutil.StrValue(devName), true
+	case "vendor":
+		return hexValue(vendor), true
+	case "product":
+		return hexValue(product), true
+	}
+	return evutil.Value{}, false
+}
+
+// matchesWhere returns whether e passes --where.
+func matchesWhere(e *evdev.InputEvent, path string, id evdev.InputID, devName string) bool {
+	if whereExpr == nil {
+		return true
+	}
+	return whereExpr.Matches(func(name string) (evutil.Value, bool) {
+		switch name {
+		case "kind":
+			return evutil.StrValue("evdev"), true
+		case "type":
+			return evutil.NamedValue(int64(e.Type), evdev.TypeName(e.Type)), true
+		case "code":
+			return codeValue(e.Type, e.Code), true
+		case "value":
+			return evutil.NumValue(int64(e.Value)), true
+		}
+		return deviceWhereVar(name, path, devName, id.Vendor, id.Product)
+	})
+}
+
+// midiValue returns the "value" of a MIDI event: the controller value, the pitch bend
+// (0 to 16383, as in simple mode), the program or the pressure.
+func midiValue(ev MidiEvent) (int64, bool) {
+	switch ev.Type {
+	case "ControlChange", "PolyPressure":
+		return int64(ev.Data2), true
+	case "ChannelPressure", "ProgramChange":
+		return int64(ev.Data1), true
+	case "PitchBend":
+		return int64(int(ev.Data1) | int(ev.Data2)<<7), true
+	}
+	return 0, false
+}
+
+// matchesMidiWhere returns whether ev passes --where.
+func matchesMidiWhere(ev MidiEvent, d *MidiDevice) bool {
+	if whereExpr == nil {
+		return true
+	}
+	isChannel := ev.Channel != 0
+	isNote := ev.Type == "NoteOn" || ev.Type == "NoteOff" || ev.Type == "PolyPressure"
+	return whereExpr.Matches(func(name string) (evutil.Value, bool) {
+		switch name {
+		case "kind":
+			return evutil.StrValue("midi"), true
+		case "type", "midi.type":
+			return evutil.NamedValue(int64(ev.Status), ev.Type), true
+		case "channel", "midi.channel":
+			return evutil.NumValue(int64(ev.Channel)), isChannel
+		case "note", "midi.note":
+			return evutil.NamedValue(int64(ev.Data1), noteName(ev.Data1)), isNote
+		case "velocity", "midi.velocity":
+			return evutil.NumValue(int64(ev.Data2)), ev.Type == "NoteOn" || ev.Type == "NoteOff"
+		case "controller", "midi.controller":
+			return evutil.NamedValue(int64(ev.Data1), ccName(ev.Data1)), ev.Type == "ControlChange"
+		case "value", "midi.value":
+			v, ok := midiValue(ev)
+			return evutil.NumValue(v), ok
+		case "midi.data1":
+			return evutil.NumValue(int64(ev.Data1)), true
+		case "midi.data2":
+			return evutil.NumValue(int64(ev.Data2)), true
+		}
+		return deviceWhereVar(name, d.path, d.name, d.vendor, d.product)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestMatchesWhere(t *testing.T) {
+	defer func() { whereExpr = nil }()
+
+	id := evdev.InputID{Vendor: 0x046d, Product: 0xc31c}
+	key := &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_F5, Value: 1}
+	repeat := &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_F5, Value: 2}
+	rel := &evdev.InputEvent{Type: evdev.EV_REL, Code: evdev.REL_X, Value: -3}
+	click := &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.BTN_LEFT, Value: 1}
+
+	noteOn := MidiEvent{Status: 0x99, Channel: 10, Data1: 36, Data2: 127, Type: "NoteOn"}
+	cc := MidiEvent{Status: 0xB0, Channel: 1, Data1: 1, Data2: 64, Type: "ControlChange"}
+	midi := &MidiDevice{path: "/dev/snd/midiC1D0", name: "DONNER DMK25Pro", vendor: 0x1234, product: 0x5678}
+
+	tests := []struct {
+		expr                                string
+		key, repeat, rel, click, noteOn, cc bool
+	}{
+		{`type == EV_KEY && value != 2 && code =~ "KEY_F.*"`, true, false, false, false, false, false},
+		{`vendor == 0x046d && value < 0`, false, false, true, false, false, false},
+		{`vendor =~ "^046d$"`, true, true, true, true, false, false},
+		{`kind == midi`, false, false, false, false, true, true},
+		{`midi.type == NoteOn && midi.channel == 10 && velocity > 100`, false, false, false, false, true, false},
+		{`type == NoteOn`, false, false, false, false, true, false},
+		{`note == C2`, false, false, false, false, true, false},
+		{`controller == "Modulation Wheel" && value == 64`, false, false, false, false, false, true},
+		{`name =~ "(?i)donner" || path == /dev/input/event3`, true, true, true, true, true, true},
+		// BTN_LEFT is "BTN_MOUSE/BTN_LEFT" in evdev.
+		{`code == BTN_LEFT`, false, false, false, true, false, false},
+		{`code == BTN_MOUSE && type == EV_KEY`, false, false, false, true, false, false},
+		{`code != KEY_F5`, false, false, true, true, true, true},
+		// REL_X is 0 too.
+		{`code == KEY_RESERVED`, false, false, false, false, false, false},
+	}
+	for _, tt := range tests {
+		var err error
+		whereExpr, err = parseWhere(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q) failed: %v", tt.expr, err)
+		}
+		check := func(what string, got, want bool) {
+			if got != want {
+				t.Errorf("%q on %s = %v, want %v", tt.expr, what, got, want)
+			}
+		}
+		check("key", matchesWhere(key, "/dev/input/event3", id, "Keyboard"), tt.key)
+		check("repeat", matchesWhere(repeat, "/dev/input/event3", id, "Keyboard"), tt.repeat)
+		check("rel", matchesWhere(rel, "/dev/input/event3", id, "Mouse"), tt.rel)
+		check("click", matchesWhere(click, "/dev/input/event3", id, "Mouse"), tt.click)
+		check("noteOn", matchesMidiWhere(noteOn, midi), tt.noteOn)
+		check("cc", matchesMidiWhere(cc, midi), tt.cc)
+	}
+}
+
+func TestWhereNameErrors(t *testing.T) {
+	for _, expr := range []string{
+		`type == EV_KYE`,
+		`code == KEY_AA`,
+		`code != BTN_LEFTT`,
+		`midi.type == EV_KEY`,
+		`type == Noteon`,
+		`kind == usb`,
+	} {
+		if _, err := parseWhere(expr); err == nil {
+			t.Errorf("parseWhere(%q) succeeded", expr)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package evutil
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// IDDevice is a Device with vendor, product and bus IDs.
+type IDDevice interface {
+	Device
+	InputID() (evdev.InputID, error)
+}
+
+// PhysDevice is a Device with a physical location, e.g. "usb-0000:00:14.0-2/input0".
+type PhysDevice interface {
+	Device
+	PhysicalLocation() (string, error)
+}
+
+// UniqDevice is a Device with a unique ID, such as a serial number.
+type UniqDevice interface {
+	Device
+	UniqueID() (string, error)
+}
+
+// AttributeKeys are the keys of the KEY=VALUE filters.
+var AttributeKeys = []string{"vendor", "product", "bus", "phys", "uniq"}
+
+// AttributeSelector selects devices by an attribute, e.g. vendor=046d or bus=usb|bluetooth.
+type AttributeSelector struct {
+	key     string
+	value   string
+	numbers []uint16         // vendor, product and bus
+	globs   []*regexp.Regexp // phys and uniq
+}
+
+var _ = Explainer((*AttributeSelector)(nil))
+
+// BusName returns the name of a bus type, e.g. "usb" for BUS_USB.
+func BusName(bus uint16) string {
+	name, ok := evdev.BUSToString[evdev.EvCode(bus)]
+	if !ok {
+		return fmt.Sprintf("%04x", bus)
+	}
+	return strings.ToLower(strings.TrimPrefix(name, "BUS_"))
+}
+
+func parseBus(s string) (uint16, error) {
+	if c, ok := evdev.BUSFromString["BUS_"+strings.ToUpper(s)]; ok {
+		return uint16(c), nil
+	}
+	return parseHexID(s)
+}
+
+func parseHexID(s string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID %q", s)
+	}
+	return uint16(v), nil
+}
+
+// globToRegexp converts a glob, where * and ? also match "/", to a regular expression.
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// NewAttributeSelector returns a selector for KEY=VALUE. VALUE can have alternatives
+// separated by "|". phys and uniq take globs.
+func NewAttributeSelector(key, value string) (*AttributeSelector, error) {
+	s := &AttributeSelector{key: key, value: value}
+	for _, v := range strings.Split(value, "|") {
+		switch key {
+		case "vendor", "product":
+			n, err := parseHexID(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s=%s: %w", key, value, err)
+			}
+			s.numbers = append(s.numbers, n)
+		case "bus":
+			n, err := parseBus(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s=%s: unknown bus %q", key, value, v)
+			}
+			s.numbers = append(s.numbers, n)
+		case "phys", "uniq":
+			s.globs = append(s.globs, globToRegexp(v))
+		default:
+			return nil, fmt.Errorf("unknown attribute %q", key)
+		}
+	}
+	return s, nil
+}
+
+func (s *AttributeSelector) IsPositive() bool {
+	return true
+}
+
+func (s *AttributeSelector) String() string {
+	return s.key + "=" + s.value
+}
+
+func (s *AttributeSelector) matchesNumber(n uint16) *bool {
+	for _, v := range s.numbers {
+		if n == v {
+			return ptrue
+		}
+	}
+	return nil
+}
+
+func (s *AttributeSelector) matchesString(str string) *bool {
+	for _, re := range s.globs {
+		if re.MatchString(str) {
+			return ptrue
+		}
+	}
+	return nil
+}
+
+// attribute returns the attribute of d, as a number for vendor, product and bus, or as a string
+// for phys and uniq.
+func (s *AttributeSelector) attribute(d Device) (n uint16, str string, ok bool) {
+	switch s.key {
+	case "vendor", "product", "bus":
+		idd, ok := d.(IDDevice)
+		if !ok {
+			return 0, "", false
+		}
+		id, err := idd.InputID()
+		if err != nil {
+			return 0, "", false
+		}
+		switch s.key {
+		case "vendor":
+			return id.Vendor, "", true
+		case "product":
+			return id.Product, "", true
+		}
+		return id.BusType, "", true
+	case "phys":
+		pd, ok := d.(PhysDevice)
+		if !ok {
+			return 0, "", false
+		}
+		phys, err := pd.PhysicalLocation()
+		return 0, phys, err == nil
+	default: // "uniq"
+		ud, ok := d.(UniqDevice)
+		if !ok {
+			return 0, "", false
+		}
+		uniq, err := ud.UniqueID()
+		return 0, uniq, err == nil
+	}
+}
+
+func (s *AttributeSelector) Matches(d Device) *bool {
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *AttributeSelector) Explain(d Device) (*bool, string) {
+	n, str, ok := s.attribute(d)
+	switch {
+	case !ok:
+		return nil, "the device has no " + s.key
+	case s.key == "bus":
+		return s.matchesNumber(n), "bus is " + BusName(n)
+	case s.globs == nil:
+		return s.matchesNumber(n), fmt.Sprintf("%s is %04x", s.key, n)
+	}
+	return s.matchesString(str), fmt.Sprintf("%s is %q", s.key, str)
+}
@@ -0,0 +1,80 @@
+package evutil
+
+import (
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+type testDevice struct {
+	id   evdev.InputID
+	phys string
+	uniq string
+}
+
+func (d *testDevice) Path() string                      { return "/dev/input/event3" }
+func (d *testDevice) Name() (string, error)             { return "Test Device", nil }
+func (d *testDevice) InputID() (evdev.InputID, error)   { return d.id, nil }
+func (d *testDevice) PhysicalLocation() (string, error) { return d.phys, nil }
+func (d *testDevice) UniqueID() (string, error)         { return d.uniq, nil }
+
+type pathOnlyDevice struct{}
+
+func (d *pathOnlyDevice) Path() string          { return "/dev/input/event4" }
+func (d *pathOnlyDevice) Name() (string, error) { return "Path Only", nil }
+
+func TestAttributeSelector(t *testing.T) {
+	d := &testDevice{
+		id:   evdev.InputID{BusType: evdev.BUS_USB, Vendor: 0x046d, Product: 0xc31c},
+		phys: "usb-0000:00:14.0-2/input0",
+		uniq: "ABC123",
+	}
+	tests := []struct {
+		key, value string
+		want       bool
+	}{
+		{"vendor", "046d", true},
+		{"vendor", "0x046D", true},
+		{"vendor", "046e", false},
+		{"product", "c52b|c31c", true},
+		{"bus", "usb", true},
+		{"bus", "bluetooth|USB", true},
+		{"bus", "3", true},
+		{"bus", "bluetooth", false},
+		{"phys", "usb-0000:00:14.0-2/*", true},
+		{"phys", "usb-*-2/input?", true},
+		{"phys", "usb-0000:00:14.0-3*", false},
+		{"uniq", "ABC*", true},
+		{"uniq", "abc*", false},
+	}
+	for _, tt := range tests {
+		s, err := NewAttributeSelector(tt.key, tt.value)
+		if err != nil {
+			t.Errorf("%s=%s: %v", tt.key, tt.value, err)
+			continue
+		}
+		if got := Matches(NewCombinedSelector().Add(s), d); got != tt.want {
+			t.Errorf("%s=%s: got %v, want %v", tt.key, tt.value, got, tt.want)
+		}
+		if got := s.Matches(&pathOnlyDevice{}); got != nil {
+			t.Errorf("%s=%s: matched a device without attributes", tt.key, tt.value)
+		}
+	}
+}
+
+func TestAttributeSelectorErrors(t *testing.T) {
+	for _, arg := range [][2]string{{"vendor", "xyz"}, {"product", "12345"}, {"bus", "floppy"}, {"color", "red"}} {
+		if _, err := NewAttributeSelector(arg[0], arg[1]); err == nil {
+			t.Errorf("%s=%s: expected an error", arg[0], arg[1])
+		}
+	}
+}
+
+func TestBusName(t *testing.T) {
+	if got := BusName(evdev.BUS_BLUETOOTH); got != "bluetooth" {
+		t.Errorf("got %q", got)
+	}
+	if got := BusName(0x7777); got != "7777" {
+		t.Errorf("got %q", got)
+	}
+}
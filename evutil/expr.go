@@ -0,0 +1,506 @@
+package evutil
+
+// A small expression language for filtering events, e.g.
+//
+//	type == EV_KEY && value != 2 && code =~ "KEY_F.*"
+//
+// Values have a number and/or a string form. Comparisons use the numbers when both sides
+// have one, and the strings otherwise, so "type == EV_KEY" and "type == 1" both work.
+// On the right side of == and !=, identifiers that aren't variables are strings, so names
+// don't need quoting. Anywhere else, they're errors, so that a misspelled variable, as in
+// "tpye == EV_KEY", doesn't silently compare a string.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Value struct {
+	Num    int64
+	Str    string
+	HasNum bool
+	HasStr bool
+
+	// Group, when both values have one, must also be the same for them to be equal, e.g. the
+	// event type of an event code, so that KEY_ESC isn't equal to REL_Y, which is also 1.
+	Group string
+}
+
+// NumValue returns a number, whose string form is the decimal representation.
+func NumValue(n int64) Value {
+	return Value{Num: n, Str: strconv.FormatInt(n, 10), HasNum: true, HasStr: true}
+}
+
+func StrValue(s string) Value {
+	return Value{Str: s, HasStr: true}
+}
+
+// NamedValue returns a number with a name, such as an event type or code.
+func NamedValue(n int64, name string) Value {
+	return Value{Num: n, Str: name, HasNum: true, HasStr: true}
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return NumValue(1)
+	}
+	return NumValue(0)
+}
+
+func (v Value) truthy() bool {
+	if v.HasNum {
+		return v.Num != 0
+	}
+	return v.HasStr && v.Str != ""
+}
+
+func (v Value) equals(o Value) bool {
+	if v.Group != "" && o.Group != "" && v.Group != o.Group {
+		return false
+	}
+	if v.HasNum && o.HasNum {
+		return v.Num == o.Num
+	}
+	if v.HasStr && o.HasStr {
+		return v.Str == o.Str
+	}
+	return false
+}
+
+// Vars returns the value of a variable. ok is false if the variable doesn't apply
+// to the current event (e.g. a MIDI field for an evdev event); such variables aren't
+// equal to anything.
+type Vars func(name string) (v Value, ok bool)
+
+type node interface {
+	eval(vars Vars) Value
+}
+
+type literalNode struct {
+	v Value
+}
+
+func (n *literalNode) eval(vars Vars) Value {
+	return n.v
+}
+
+type varNode struct {
+	name string
+}
+
+func (n *varNode) eval(vars Vars) Value {
+	v, ok := vars(n.name)
+	if !ok {
+		return Value{}
+	}
+	return v
+}
+
+type notNode struct {
+	x node
+}
+
+func (n *notNode) eval(vars Vars) Value {
+	return boolValue(!n.x.eval(vars).truthy())
+}
+
+type andNode struct {
+	x, y node
+}
+
+func (n *andNode) eval(vars Vars) Value {
+	return boolValue(n.x.eval(vars).truthy() && n.y.eval(vars).truthy())
+}
+
+type orNode struct {
+	x, y node
+}
+
+func (n *orNode) eval(vars Vars) Value {
+	return boolValue(n.x.eval(vars).truthy() || n.y.eval(vars).truthy())
+}
+
+type compareNode struct {
+	op   string
+	x, y node
+}
+
+func (n *compareNode) eval(vars Vars) Value {
+	x := n.x.eval(vars)
+	y := n.y.eval(vars)
+	switch n.op {
+	case "==":
+		return boolValue(x.equals(y))
+	case "!=":
+		return boolValue(!x.equals(y))
+	}
+	if !x.HasNum || !y.HasNum {
+		return boolValue(false)
+	}
+	switch n.op {
+	case "<":
+		return boolValue(x.Num < y.Num)
+	case "<=":
+		return boolValue(x.Num <= y.Num)
+	case ">":
+		return boolValue(x.Num > y.Num)
+	default: // ">="
+		return boolValue(x.Num >= y.Num)
+	}
+}
+
+type matchNode struct {
+	negate bool
+	x, y   node
+	cache  map[string]*regexp.Regexp // For patterns that aren't literals.
+}
+
+func (n *matchNode) eval(vars Vars) Value {
+	x := n.x.eval(vars)
+	y := n.y.eval(vars)
+	if !x.HasStr || !y.HasStr {
+		return boolValue(n.negate)
+	}
+	re, ok := n.cache[y.Str]
+	if !ok {
+		re, _ = regexp.Compile(y.Str) // An invalid pattern matches nothing.
+		n.cache[y.Str] = re
+	}
+	if re == nil {
+		return boolValue(n.negate)
+	}
+	return boolValue(re.MatchString(x.Str) != n.negate)
+}
+
+type Expr struct {
+	source string
+	root   node
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Matches evaluates the expression with vars, and returns whether the result is true.
+func (e *Expr) Matches(vars Vars) bool {
+	return e.root.eval(vars).truthy()
+}
+
+// MayMatch returns whether the expression can be true for an event with the known variables,
+// whatever the values of the others. known returns ok == false for the variables that aren't
+// known yet. It's used to drop events that can't match before reading them, e.g. by event type.
+func (e *Expr) MayMatch(known func(name string) (v Value, ok bool)) bool {
+	v, ok := partialEval(e.root, known)
+	return !ok || v.truthy()
+}
+
+// partialEval evaluates n with only the known variables. ok is false if the result depends on
+// the unknown ones.
+func partialEval(n node, known func(name string) (Value, bool)) (v Value, ok bool) {
+	switch n := n.(type) {
+	case *literalNode:
+		return n.v, true
+	case *varNode:
+		return known(n.name)
+	case *notNode:
+		x, ok := partialEval(n.x, known)
+		return boolValue(!x.truthy()), ok
+	case *andNode:
+		x, xok := partialEval(n.x, known)
+		y, yok := partialEval(n.y, known)
+		if (xok && !x.truthy()) || (yok && !y.truthy()) {
+			return boolValue(false), true
+		}
+		return boolValue(true), xok && yok
+	case *orNode:
+		x, xok := partialEval(n.x, known)
+		y, yok := partialEval(n.y, known)
+		if (xok && x.truthy()) || (yok && y.truthy()) {
+			return boolValue(true), true
+		}
+		return boolValue(false), xok && yok
+	case *compareNode:
+		if _, ok := partialEval(n.x, known); !ok {
+			return Value{}, false
+		}
+		if _, ok := partialEval(n.y, known); !ok {
+			return Value{}, false
+		}
+	case *matchNode:
+		if _, ok := partialEval(n.x, known); !ok {
+			return Value{}, false
+		}
+		if _, ok := partialEval(n.y, known); !ok {
+			return Value{}, false
+		}
+	}
+	// All the variables are known.
+	return n.eval(known), true
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")"}
+
+// Identifiers can contain "/", so paths don't need quoting, and "-" after the first character.
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '.' || r == '/' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func tokenize(s string) ([]token, error) {
+	var ret []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				sb.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			ret = append(ret, token{tokString, sb.String(), i})
+			i = j + 1
+		case isIdentRune(rune(c)) || c >= 0x80:
+			j := i
+			for j < len(s) {
+				r := rune(s[j])
+				if !isIdentRune(r) && r != '-' && r < 0x80 {
+					break
+				}
+				j++
+			}
+			kind := tokIdent
+			if c >= '0' && c <= '9' {
+				kind = tokNumber
+			}
+			ret = append(ret, token{kind, s[i:j], i})
+			i = j
+		case c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i + 1
+			for j < len(s) && isIdentRune(rune(s[j])) {
+				j++
+			}
+			ret = append(ret, token{tokNumber, s[i:j], i})
+			i = j
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					ret = append(ret, token{tokOp, op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i+1)
+			}
+		}
+	}
+	return append(ret, token{tokEOF, "", len(s)}), nil
+}
+
+type exprParser struct {
+	tokens  []token
+	next    int
+	isVar   map[string]bool
+	resolve NameResolver
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) accept(op string) bool {
+	t := p.peek()
+	if t.kind == tokOp && t.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), t.pos+1)
+}
+
+func (p *exprParser) parseOr() (node, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &orNode{x, y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseAnd() (node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &andNode{x, y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseUnary() (node, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (node, error) {
+	x, err := p.parsePrimary(false)
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp {
+		return x, nil
+	}
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next++
+		yt := p.peek()
+		y, err := p.parsePrimary(t.text == "==" || t.text == "!=")
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := x.(*varNode); ok && p.resolve != nil && yt.kind == tokIdent && !p.isVar[yt.text] {
+			value, err := p.resolve(v.name, yt.text)
+			if err != nil {
+				return nil, p.errorf(yt, "%v", err)
+			}
+			if value.HasNum || value.HasStr {
+				y = &literalNode{value}
+			}
+		}
+		return &compareNode{t.text, x, y}, nil
+	case "=~", "!~":
+		p.next++
+		y, err := p.parsePrimary(false)
+		if err != nil {
+			return nil, err
+		}
+		n := &matchNode{negate: t.text == "!~", x: x, y: y, cache: make(map[string]*regexp.Regexp)}
+		if lit, ok := y.(*literalNode); ok {
+			re, err := regexp.Compile(lit.v.Str)
+			if err != nil {
+				return nil, p.errorf(t, "invalid regular expression %q", lit.v.Str)
+			}
+			n.cache[lit.v.Str] = re
+		}
+		return n, nil
+	}
+	return x, nil
+}
+
+// parsePrimary parses a value. names is whether identifiers that aren't variables are allowed,
+// as strings.
+func (p *exprParser) parsePrimary(names bool) (node, error) {
+	t := p.peek()
+	p.next++
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseInt(t.text, 0, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.text)
+		}
+		return &literalNode{NumValue(n)}, nil
+	case tokString:
+		return &literalNode{StrValue(t.text)}, nil
+	case tokIdent:
+		if p.isVar[t.text] {
+			return &varNode{t.text}, nil
+		}
+		if !names {
+			return nil, p.errorf(t, "unknown field %q", t.text)
+		}
+		return &literalNode{StrValue(t.text)}, nil
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf(p.peek(), "missing )")
+			}
+			return x, nil
+		}
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return nil, p.errorf(t, "unexpected end of expression")
+}
+
+// NameResolver returns the value of name in "variable == name" or "variable != name", e.g. the
+// number of an event code, or an error if name isn't valid for the variable. If it returns
+// the zero Value, name is a string.
+type NameResolver func(variable, name string) (Value, error)
+
+// ParseExpr parses an expression. varNames are the identifiers that are variables.
+func ParseExpr(s string, varNames []string) (*Expr, error) {
+	return ParseExprWithNames(s, varNames, nil)
+}
+
+// ParseExprWithNames parses an expression, resolving the names compared with variables
+// with resolve.
+func ParseExprWithNames(s string, varNames []string, resolve NameResolver) (*Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, isVar: make(map[string]bool), resolve: resolve}
+	for _, v := range varNames {
+		p.isVar[v] = true
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return &Expr{source: s, root: root}, nil
+}
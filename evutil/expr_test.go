@@ -0,0 +1,162 @@
+package evutil
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testVars(name string) (Value, bool) {
+	switch name {
+	case "type":
+		return NamedValue(1, "EV_KEY"), true
+	case "code":
+		return NamedValue(59, "KEY_F1"), true
+	case "value":
+		return NumValue(1), true
+	case "name":
+		return StrValue("Logitech USB Keyboard"), true
+	}
+	return Value{}, false
+}
+
+var testVarNames = []string{"type", "code", "value", "name", "velocity"}
+
+func TestExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`type == EV_KEY`, true},
+		{`type == 1`, true},
+		{`type == 0x01`, true},
+		{`type != EV_KEY`, false},
+		{`type == EV_REL`, false},
+		{`code =~ "KEY_F.*"`, true},
+		{`code !~ "^KEY_F"`, false},
+		{`code =~ 'f1'`, false},
+		{`name =~ "(?i)logitech"`, true},
+		{`type == EV_KEY && value != 2 && code =~ "KEY_F.*"`, true},
+		{`value > 0 && value < 2`, true},
+		{`value >= 2 || value <= -1`, false},
+		{`!(value == 1)`, false},
+		{`! value == 0`, true},
+		{`value`, true},
+		{`name == "Logitech USB Keyboard"`, true},
+		{`type == EV_REL || (code == KEY_F1 && value == 1)`, true},
+
+		// Variables that don't apply to the event are never equal, and never compare.
+		{`velocity > 100`, false},
+		{`velocity == velocity`, false},
+		{`velocity != 100`, true},
+		{`velocity`, false},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.expr, testVarNames)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := e.Matches(testVars); got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`type ==`,
+		`(type == EV_KEY`,
+		`type == EV_KEY)`,
+		`code =~ "["`,
+		`name == "unterminated`,
+		`value == 12abc`,
+		`type = EV_KEY`,
+		`tpye == EV_KEY`,
+		`EV_KEY == type`,
+		`type == EV_KEY && tpye`,
+		`value > high`,
+		`code =~ KEY_F`,
+	} {
+		if _, err := ParseExpr(expr, testVarNames); err == nil {
+			t.Errorf("ParseExpr(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestExprPaths(t *testing.T) {
+	e, err := ParseExpr(`path == /dev/input/by-id/usb-Logitech-event-kbd && value>-1`, []string{"path", "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := func(name string) (Value, bool) {
+		if name == "path" {
+			return StrValue("/dev/input/by-id/usb-Logitech-event-kbd"), true
+		}
+		return NumValue(0), true
+	}
+	if !e.Matches(vars) {
+		t.Errorf("%v didn't match", e)
+	}
+}
+
+func TestExprNames(t *testing.T) {
+	resolve := func(variable, name string) (Value, error) {
+		if variable != "code" {
+			return Value{}, nil
+		}
+		if name != "KEY_ESC" {
+			return Value{}, fmt.Errorf("unknown code %q", name)
+		}
+		v := NamedValue(1, name)
+		v.Group = "EV_KEY"
+		return v, nil
+	}
+	e, err := ParseExprWithNames(`code == KEY_ESC && name == KEY_ESC`, []string{"code", "name"}, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for group, want := range map[string]bool{"EV_KEY": true, "EV_REL": false} {
+		vars := func(name string) (Value, bool) {
+			if name == "name" {
+				return StrValue("KEY_ESC"), true
+			}
+			v := NamedValue(1, "KEY_ESC/REL_Y")
+			v.Group = group
+			return v, true
+		}
+		if got := e.Matches(vars); got != want {
+			t.Errorf("%s: got %v, want %v", group, got, want)
+		}
+	}
+	if _, err := ParseExprWithNames(`code == KEY_ECS`, []string{"code"}, resolve); err == nil {
+		t.Errorf("an unknown name was accepted")
+	}
+}
+
+func TestExprMayMatch(t *testing.T) {
+	for expr, want := range map[string]bool{
+		`type == EV_KEY && value == 1`:   true,
+		`type == EV_REL && value == 1`:   false,
+		`type == EV_REL || value == 1`:   true,
+		`!(type == EV_KEY)`:              false,
+		`type != EV_KEY || name =~ "a"`:  true,
+		`(type == 2 || type == 3) && 1`:  false,
+		`value == 1 && !(type == 1)`:     false,
+		`code == KEY_F1 || type == 0x01`: true,
+	} {
+		e, err := ParseExpr(expr, testVarNames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		known := func(name string) (Value, bool) {
+			if name == "type" {
+				return NamedValue(1, "EV_KEY"), true
+			}
+			return Value{}, false
+		}
+		if got := e.MayMatch(known); got != want {
+			t.Errorf("%s: got %v, want %v", expr, got, want)
+		}
+	}
+}
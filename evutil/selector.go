@@ -1,8 +1,13 @@
 package evutil
 
 import (
+	"fmt"
+
 	"github.com/omakoto/go-common/src/must"
+	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
 )
 
 type Device interface {
@@ -32,11 +37,48 @@ type Selector interface {
 	// Otherwise, it will remove already selected elements.
 	IsPositive() bool
 }
+
+// Explainer is a Selector that can describe itself and its result, for --explain-selection.
+// All the selectors in this package are Explainers, and their Matches is the result of Explain.
+type Explainer interface {
+	Selector
+
+	// String returns the selector in the FILTER syntax.
+	String() string
+
+	// Explain returns the result of Matches for d, and why.
+	// The reason can be empty when the result is obvious, e.g. when a regex matches the name.
+	Explain(d Device) (*bool, string)
+}
+
+// ParentSelector is a Selector with sub-selectors, such as AndSelector.
+// --explain-selection shows their results too.
+type ParentSelector interface {
+	Selector
+	Selectors() []Selector
+}
+
+// SelectorString returns sel in the FILTER syntax, or its type if it isn't an Explainer.
+func SelectorString(sel Selector) string {
+	if e, ok := sel.(Explainer); ok {
+		return e.String()
+	}
+	return fmt.Sprintf("%T", sel)
+}
+
+// explain returns the result of sel for d, and why, if sel is an Explainer.
+func explain(sel Selector, d Device) (*bool, string) {
+	if e, ok := sel.(Explainer); ok {
+		return e.Explain(d)
+	}
+	return sel.Matches(d), ""
+}
+
 type constantSelector struct {
 	b bool
 }
 
-var _ = Selector((*constantSelector)(nil))
+var _ = Explainer((*constantSelector)(nil))
 
 func NewAllSelector() Selector {
 	return &constantSelector{true}
@@ -54,11 +96,25 @@ func (s *constantSelector) Matches(d Device) *bool {
 	return &s.b
 }
 
+func (s *constantSelector) String() string {
+	if s.b {
+		return "(all)"
+	}
+	return "(none)"
+}
+
+func (s *constantSelector) Explain(d Device) (*bool, string) {
+	if s.b {
+		return s.Matches(d), "matches every device"
+	}
+	return s.Matches(d), "matches no device"
+}
+
 type NegativeSelector struct {
 	selector Selector
 }
 
-var _ = Selector((*NegativeSelector)(nil))
+var _ = Explainer((*NegativeSelector)(nil))
 
 func NewNegativeSelector(selector Selector) *NegativeSelector {
 	return &NegativeSelector{selector}
@@ -79,12 +135,27 @@ func (s *NegativeSelector) Matches(d Device) *bool {
 	return nil // false -> still unknown
 }
 
+func (s *NegativeSelector) String() string {
+	return "!" + SelectorString(s.selector)
+}
+
+func (s *NegativeSelector) Explain(d Device) (*bool, string) {
+	b := s.Matches(d)
+	if b != nil && !*b {
+		return b, SelectorString(s.selector) + " matched"
+	}
+	return b, SelectorString(s.selector) + " didn't match, so it doesn't exclude the device"
+}
+
 type CombinedSelector struct {
 	selectors []Selector
 	def       bool
 }
 
-var _ = Selector((*CombinedSelector)(nil))
+var (
+	_ = Explainer((*CombinedSelector)(nil))
+	_ = ParentSelector((*CombinedSelector)(nil))
+)
 
 func NewCombinedSelector() *CombinedSelector {
 	return &CombinedSelector{def: true}
@@ -109,43 +180,115 @@ func (s *CombinedSelector) IsEmpty() bool {
 }
 
 func (s *CombinedSelector) Matches(d Device) *bool {
-	positiveMatched := false
-	negativeMatched := false
-	for _, sel := range s.selectors {
-		b := sel.Matches(d)
-		if b == nil {
-			continue // Ignore unknowns
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *CombinedSelector) Selectors() []Selector {
+	return s.selectors
+}
+
+func (s *CombinedSelector) String() string {
+	strs := make([]string, len(s.selectors))
+	for i, sel := range s.selectors {
+		strs[i] = SelectorString(sel)
+	}
+	return strings.Join(strs, " ")
+}
+
+// Effects of a selector in a CombinedSelector.
+const (
+	EffectMatched    = "matched"
+	EffectNotMatched = "not matched"
+	EffectAbstained  = "abstained"
+	EffectExcluded   = "excluded"
+)
+
+// SelectorResult is the result of a selector for a device, with the results of its sub-selectors.
+type SelectorResult struct {
+	Selector Selector
+	Label    string // The selector in the FILTER syntax.
+	Result   *bool
+	Effect   string
+	Reason   string
+	Children []SelectorResult
+}
+
+// effectOf returns the effect of a selector's result in a CombinedSelector.
+func effectOf(sel Selector, b *bool) string {
+	if !sel.IsPositive() {
+		if b != nil && !*b {
+			return EffectExcluded
 		}
-		if sel.IsPositive() {
-			if *b {
-				positiveMatched = true
-			}
-		} else {
-			if !*b {
-				negativeMatched = true
+		return EffectAbstained
+	}
+	switch {
+	case b == nil:
+		return EffectAbstained
+	case *b:
+		return EffectMatched
+	}
+	return EffectNotMatched
+}
+
+// ExplainSelector returns the result of sel for d, and the results of its sub-selectors, recursively.
+func ExplainSelector(sel Selector, d Device) SelectorResult {
+	b, reason := explain(sel, d)
+	ret := SelectorResult{Selector: sel, Label: SelectorString(sel), Result: b, Effect: effectOf(sel, b), Reason: reason}
+	if ps, ok := sel.(ParentSelector); ok {
+		_, combined := sel.(*CombinedSelector)
+		children := ps.Selectors()
+		if !combined && len(children) == 1 && SelectorString(children[0]) == ret.Label {
+			children = nil // E.g. a FILTER in parentheses.
+		}
+		for _, child := range children {
+			r := ExplainSelector(child, d)
+			if !combined && r.Effect == EffectAbstained {
+				r.Effect = EffectNotMatched // Expressions don't use the "unknown" result.
 			}
+			ret.Children = append(ret.Children, r)
 		}
 	}
-	// If there's any positive match, return true unless there's a negative match.
-	if positiveMatched {
-		return ptr(!negativeMatched)
+	return ret
+}
+
+// Explain returns whether d is selected, and why.
+func (s *CombinedSelector) Explain(d Device) (*bool, string) {
+	var matched, excluded []string
+	for _, sel := range s.selectors {
+		b := sel.Matches(d)
+		switch {
+		case b == nil:
+			continue // Ignore unknowns
+		case sel.IsPositive() && *b:
+			matched = append(matched, SelectorString(sel))
+		case !sel.IsPositive() && !*b:
+			excluded = append(excluded, SelectorString(sel))
+		}
 	}
-	// If there's any negative match, (and there's no positive match), return false.
-	if negativeMatched {
-		return pfalse
+	switch {
+	case len(excluded) > 0:
+		// A negative match wins over any positive match.
+		return pfalse, "excluded by " + strings.Join(excluded, ", ")
+	case len(matched) > 0:
+		return ptrue, "matched " + strings.Join(matched, ", ")
+	case len(s.selectors) == 0:
+		return ptr(s.def), "there's no FILTER"
+	case s.def:
+		return ptrue, "there are only exclusions, and none of them matched"
 	}
-
-	return ptr(s.def)
+	return pfalse, "no FILTER matched"
 }
 
 type ReSelector struct {
-	regex *regexp.Regexp
+	pattern string
+	regex   *regexp.Regexp
 }
 
-var _ = Selector((*ReSelector)(nil))
+var _ = Explainer((*ReSelector)(nil))
 
 func NewReSelector(pattern string) *ReSelector {
-	return &ReSelector{regex: regexp.MustCompile("(?i)" + pattern)}
+	return &ReSelector{pattern: pattern, regex: regexp.MustCompile("(?i)" + pattern)}
 }
 
 func (s *ReSelector) IsPositive() bool {
@@ -153,29 +296,191 @@ func (s *ReSelector) IsPositive() bool {
 }
 
 func (s *ReSelector) Matches(d Device) *bool {
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *ReSelector) String() string {
+	return s.pattern
+}
+
+func (s *ReSelector) Explain(d Device) (*bool, string) {
 	if s.regex.MatchString(must.Must2(d.Name())) {
-		return ptrue
+		return ptrue, ""
+	}
+	pd, ok := d.(PortDevice)
+	if !ok {
+		return nil, "the name doesn't match"
+	}
+	for _, name := range pd.PortNames() {
+		if s.regex.MatchString(name) {
+			return ptrue, fmt.Sprintf("port %q matches", name)
+		}
 	}
-	return nil
+	return nil, "neither the name nor the port names match"
+}
+
+// PortDevice is a Device with named ports, such as the ports of a MIDI interface, which the
+// regex selector matches too.
+type PortDevice interface {
+	Device
+	PortNames() []string
+}
+
+// AliasDevice is a Device with other paths, such as /dev/input/by-id symlinks.
+type AliasDevice interface {
+	Device
+	Aliases() []string
 }
 
+// PathSelector selects devices by path, which can be a glob such as /dev/input/event1*.
+// The path can also be a symlink, such as /dev/input/by-id/..., which matches the device
+// with the alias, or the device the symlink points to.
 type PathSelector struct {
-	path string
+	path   string
+	isGlob bool
 }
 
-var _ = Selector((*PathSelector)(nil))
+var _ = Explainer((*PathSelector)(nil))
 
 func NewPathSelector(path string) *PathSelector {
-	return &PathSelector{path}
+	return &PathSelector{path, strings.ContainsAny(path, "*?[")}
 }
 
 func (s *PathSelector) IsPositive() bool {
 	return true
 }
 
+func (s *PathSelector) matches(p string) bool {
+	if !s.isGlob {
+		return p == s.path
+	}
+	matched, err := path.Match(s.path, p)
+	return matched || (err != nil && p == s.path)
+}
+
 func (s *PathSelector) Matches(d Device) *bool {
-	if d.Path() == s.path {
-		return ptrue
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *PathSelector) String() string {
+	return s.path
+}
+
+func (s *PathSelector) Explain(d Device) (*bool, string) {
+	if s.matches(d.Path()) {
+		return ptrue, ""
+	}
+	if ad, ok := d.(AliasDevice); ok {
+		for _, alias := range ad.Aliases() {
+			if s.matches(alias) {
+				return ptrue, "alias " + alias + " matches"
+			}
+		}
+	}
+	if !s.isGlob {
+		if resolved, err := filepath.EvalSymlinks(s.path); err == nil && resolved == d.Path() {
+			return ptrue, s.path + " is a symlink to the device"
+		}
+	}
+	return nil, "the path doesn't match"
+}
+
+// SiblingDevice is a Device that's a part of a physical device with other Devices, such as
+// the keyboard and the consumer control nodes of a keyboard.
+type SiblingDevice interface {
+	Device
+	Siblings() []Device
+}
+
+// SiblingSelector selects the devices that its selector matches, and all their siblings.
+type SiblingSelector struct {
+	selector Selector
+}
+
+var _ = Explainer((*SiblingSelector)(nil))
+
+func NewSiblingSelector(selector Selector) *SiblingSelector {
+	return &SiblingSelector{selector}
+}
+
+func (s *SiblingSelector) IsPositive() bool {
+	return true
+}
+
+func (s *SiblingSelector) Matches(d Device) *bool {
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *SiblingSelector) String() string {
+	return "+" + SelectorString(s.selector)
+}
+
+func (s *SiblingSelector) Explain(d Device) (*bool, string) {
+	if b, reason := explain(s.selector, d); b != nil && *b {
+		return ptrue, reason
+	}
+	if sd, ok := d.(SiblingDevice); ok {
+		for _, sibling := range sd.Siblings() {
+			if matchesStrictly(s.selector, sibling) {
+				return ptrue, "sibling " + sibling.Path() + " matches"
+			}
+		}
+	}
+	return nil, "neither the device nor its siblings match"
+}
+
+// UdevDevice is a Device with udev properties, such as ID_INPUT_KEYBOARD=1.
+type UdevDevice interface {
+	Device
+	UdevProperties() map[string]string
+}
+
+// UdevSelector selects devices with a udev property matching a glob pattern.
+type UdevSelector struct {
+	property string
+	pattern  string
+	label    string
+}
+
+var _ = Explainer((*UdevSelector)(nil))
+
+func NewUdevSelector(property, pattern string) *UdevSelector {
+	return &UdevSelector{property, pattern, "@" + property + "=" + pattern}
+}
+
+// NewClassSelector selects devices by their class, e.g. "keyboard" or "touchpad",
+// i.e. the ID_INPUT_* properties.
+func NewClassSelector(class string) *UdevSelector {
+	return &UdevSelector{"ID_INPUT_" + strings.ToUpper(class), "1", "@" + class}
+}
+
+func (s *UdevSelector) IsPositive() bool {
+	return true
+}
+
+func (s *UdevSelector) Matches(d Device) *bool {
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *UdevSelector) String() string {
+	return s.label
+}
+
+func (s *UdevSelector) Explain(d Device) (*bool, string) {
+	ud, ok := d.(UdevDevice)
+	if !ok {
+		return nil, "the device has no udev properties"
+	}
+	value, ok := ud.UdevProperties()[s.property]
+	if !ok {
+		return nil, "the device has no " + s.property
+	}
+	if matched, _ := path.Match(s.pattern, value); matched {
+		return ptrue, fmt.Sprintf("%s is %q", s.property, value)
 	}
-	return nil
+	return nil, fmt.Sprintf("%s is %q", s.property, value)
 }
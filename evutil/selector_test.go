@@ -0,0 +1,93 @@
+package evutil
+
+import "testing"
+
+func TestCombinedSelectorExplain(t *testing.T) {
+	sel := NewCombinedSelector().
+		Add(NewReSelector("logitech")).
+		Add(NewNegativeSelector(NewReSelector("mouse"))).
+		Add(NewClassSelector("keyboard"))
+	if got, want := sel.String(), "logitech !mouse @keyboard"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	ex := ExplainSelector(sel, &namedDevice{name: "Logitech Mouse"})
+	if Matches(sel, &namedDevice{name: "Logitech Mouse"}) || ex.Reason != "excluded by !mouse" {
+		t.Errorf("got %v, %q", *ex.Result, ex.Reason)
+	}
+	results := []struct{ effect, reason string }{
+		{EffectMatched, ""},
+		{EffectExcluded, "mouse matched"},
+		{EffectAbstained, "the device has no udev properties"},
+	}
+	for i, r := range ex.Children {
+		if r.Effect != results[i].effect || r.Reason != results[i].reason {
+			t.Errorf("%s: got %s: %q, want %s: %q", r.Label, r.Effect, r.Reason, results[i].effect, results[i].reason)
+		}
+	}
+
+	ex = ExplainSelector(NewCombinedSelector(), &namedDevice{name: "Logitech Mouse"})
+	if !*ex.Result || ex.Reason != "there's no FILTER" {
+		t.Errorf("got %v, %q", *ex.Result, ex.Reason)
+	}
+}
+
+func TestExpressionExplain(t *testing.T) {
+	sel, err := ParseSelector("logitech & !(mouse | vendor=1b1c)", testLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type node struct {
+		depth          int
+		sel            string
+		effect, reason string
+	}
+	var got []node
+	var walk func(r SelectorResult, depth int)
+	walk = func(r SelectorResult, depth int) {
+		got = append(got, node{depth, r.Label, r.Effect, r.Reason})
+		for _, c := range r.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(ExplainSelector(sel, &namedDevice{name: "Logitech Mouse", vendor: 0x046d}), 0)
+	want := []node{
+		{0, "(logitech & !(mouse | vendor=1b1c))", EffectNotMatched, "!(mouse | vendor=1b1c) didn't match"},
+		{1, "logitech", EffectMatched, ""},
+		{1, "!(mouse | vendor=1b1c)", EffectNotMatched, "(mouse | vendor=1b1c) matched"},
+		{2, "(mouse | vendor=1b1c)", EffectMatched, "mouse matched"},
+		{3, "mouse", EffectMatched, ""},
+		{3, "vendor=1b1c", EffectNotMatched, "vendor is 046d"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got[i], want[i])
+		}
+	}
+
+	// A single FILTER in parentheses has no sub-selector.
+	sel, _ = ParseSelector("(vendor=046d)", testLeaf)
+	if r := ExplainSelector(sel, &namedDevice{vendor: 0x1b1c}); r.Effect != EffectNotMatched || r.Reason != "vendor is 1b1c" || len(r.Children) != 0 {
+		t.Errorf("got %+v", r)
+	}
+}
+
+// plainSelector is a Selector that isn't an Explainer, as outside this package.
+type plainSelector struct{}
+
+func (s *plainSelector) Matches(d Device) *bool { return ptrue }
+func (s *plainSelector) IsPositive() bool       { return true }
+
+func TestExplainPlainSelector(t *testing.T) {
+	sel := NewCombinedSelector().Add(NewAndSelector(&plainSelector{}, NewReSelector("mouse")))
+	r := ExplainSelector(sel, &namedDevice{name: "Logitech Mouse"})
+	if !*r.Result || r.Reason != "matched (*evutil.plainSelector & mouse)" {
+		t.Errorf("got %v, %q", *r.Result, r.Reason)
+	}
+	if c := r.Children[0].Children[0]; c.Label != "*evutil.plainSelector" || c.Effect != EffectMatched || c.Reason != "" {
+		t.Errorf("got %+v", c)
+	}
+}
@@ -0,0 +1,314 @@
+package evutil
+
+// Selector expressions, e.g.
+//
+//	(logitech & keyboard) | vendor=1b1c & !consumer
+//
+// "&" is AND, "|" is OR and "!" is NOT, with the usual precedence; parentheses group.
+// Everything else is a single FILTER, which is parsed by the caller, so
+// "AT Translated & !mouse" has two FILTERs: "AT Translated" and "mouse".
+// A FILTER containing operator characters can be quoted, e.g. "'key(board|pad)' & !virtual".
+// In KEY=VALUE FILTERs, a "|" that isn't surrounded by spaces separates alternative values,
+// so "bus=usb|bluetooth & keyboard" works as expected.
+//
+// Unlike CombinedSelector, the nodes don't use the "unknown" (nil) result: a FILTER matches
+// only when its selector returns true, and the nodes always return true or false.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsSelectorExpr returns whether a FILTER argument should be parsed with ParseSelector,
+// i.e. it contains "&", " | " or starts with "(".
+// Other arguments, like "foo|bar" or "!mouse", are single FILTERs.
+func IsSelectorExpr(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.Contains(s, "&") || strings.Contains(s, " |") || strings.Contains(s, "| ") ||
+		strings.HasPrefix(strings.TrimLeft(s, "! "), "(")
+}
+
+// matchesStrictly returns whether sel definitely matches d.
+func matchesStrictly(sel Selector, d Device) bool {
+	b := sel.Matches(d)
+	return b != nil && *b
+}
+
+// AndSelector matches devices that all of its selectors match.
+type AndSelector struct {
+	selectors []Selector
+}
+
+var (
+	_ = Explainer((*AndSelector)(nil))
+	_ = ParentSelector((*AndSelector)(nil))
+)
+
+func NewAndSelector(selectors ...Selector) *AndSelector {
+	return &AndSelector{selectors}
+}
+
+func (s *AndSelector) IsPositive() bool {
+	return true
+}
+
+func (s *AndSelector) String() string {
+	return joinSelectors(s.selectors, " & ")
+}
+
+func (s *AndSelector) Matches(d Device) *bool {
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *AndSelector) Selectors() []Selector {
+	return s.selectors
+}
+
+func (s *AndSelector) Explain(d Device) (*bool, string) {
+	if len(s.selectors) == 1 {
+		b, reason := explain(s.selectors[0], d)
+		return ptr(b != nil && *b), reason
+	}
+	for _, sel := range s.selectors {
+		if !matchesStrictly(sel, d) {
+			return pfalse, SelectorString(sel) + " didn't match"
+		}
+	}
+	return ptrue, ""
+}
+
+// OrSelector matches devices that any of its selectors match.
+type OrSelector struct {
+	selectors []Selector
+}
+
+var (
+	_ = Explainer((*OrSelector)(nil))
+	_ = ParentSelector((*OrSelector)(nil))
+)
+
+func NewOrSelector(selectors ...Selector) *OrSelector {
+	return &OrSelector{selectors}
+}
+
+func (s *OrSelector) IsPositive() bool {
+	return true
+}
+
+func (s *OrSelector) String() string {
+	return joinSelectors(s.selectors, " | ")
+}
+
+func (s *OrSelector) Matches(d Device) *bool {
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *OrSelector) Selectors() []Selector {
+	return s.selectors
+}
+
+func (s *OrSelector) Explain(d Device) (*bool, string) {
+	for _, sel := range s.selectors {
+		if matchesStrictly(sel, d) {
+			return ptrue, SelectorString(sel) + " matched"
+		}
+	}
+	return pfalse, "none of them matched"
+}
+
+// NotSelector matches devices that its selector doesn't match. Unlike NegativeSelector, it's
+// a positive selector; "!mouse" as an expression selects everything that isn't a mouse.
+type NotSelector struct {
+	selector Selector
+}
+
+var (
+	_ = Explainer((*NotSelector)(nil))
+	_ = ParentSelector((*NotSelector)(nil))
+)
+
+func NewNotSelector(selector Selector) *NotSelector {
+	return &NotSelector{selector}
+}
+
+func (s *NotSelector) IsPositive() bool {
+	return true
+}
+
+func (s *NotSelector) String() string {
+	return "!" + SelectorString(s.selector)
+}
+
+func (s *NotSelector) Matches(d Device) *bool {
+	b, _ := s.Explain(d)
+	return b
+}
+
+func (s *NotSelector) Selectors() []Selector {
+	return []Selector{s.selector}
+}
+
+func (s *NotSelector) Explain(d Device) (*bool, string) {
+	if matchesStrictly(s.selector, d) {
+		return pfalse, SelectorString(s.selector) + " matched"
+	}
+	return ptrue, SelectorString(s.selector) + " didn't match"
+}
+
+// joinSelectors returns the selectors joined with op, in parentheses if there's more than one.
+func joinSelectors(selectors []Selector, op string) string {
+	if len(selectors) == 1 {
+		return SelectorString(selectors[0])
+	}
+	strs := make([]string, len(selectors))
+	for i, sel := range selectors {
+		strs[i] = SelectorString(sel)
+	}
+	return "(" + strings.Join(strs, op) + ")"
+}
+
+type selectorParser struct {
+	s    string
+	pos  int
+	leaf func(string) (Selector, error)
+}
+
+func (p *selectorParser) skipSpaces() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// accept consumes op, if it's next.
+func (p *selectorParser) accept(op byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.s) && p.s[p.pos] == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s at position %d in %q", fmt.Sprintf(format, args...), p.pos+1, p.s)
+}
+
+func (p *selectorParser) parseOr() (Selector, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := []Selector{x}
+	for p.accept('|') {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, y)
+	}
+	if len(or) == 1 {
+		return x, nil
+	}
+	return NewOrSelector(or...), nil
+}
+
+func (p *selectorParser) parseAnd() (Selector, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	and := []Selector{x}
+	for p.accept('&') {
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, y)
+	}
+	if len(and) == 1 {
+		return x, nil
+	}
+	return NewAndSelector(and...), nil
+}
+
+func (p *selectorParser) parseUnary() (Selector, error) {
+	if p.accept('!') {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return NewNotSelector(x), nil
+	}
+	if p.accept('(') {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, p.errorf("missing )")
+		}
+		return x, nil
+	}
+	return p.parseLeaf()
+}
+
+func (p *selectorParser) parseLeaf() (Selector, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("missing FILTER")
+	}
+	start := p.pos
+	var word string
+	if q := p.s[p.pos]; q == '"' || q == '\'' {
+		end := strings.IndexByte(p.s[p.pos+1:], q)
+		if end < 0 {
+			return nil, p.errorf("unterminated quote")
+		}
+		word = p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else {
+	loop:
+		for ; p.pos < len(p.s); p.pos++ {
+			switch p.s[p.pos] {
+			case '&', '(', ')':
+				break loop
+			case '|':
+				// An alternative value in KEY=VALUE, unless there are spaces around it.
+				w := p.s[start:p.pos]
+				if !strings.Contains(w, "=") || strings.HasSuffix(w, " ") ||
+					p.pos+1 >= len(p.s) || p.s[p.pos+1] == ' ' {
+					break loop
+				}
+			}
+		}
+		word = strings.TrimSpace(p.s[start:p.pos])
+	}
+	if word == "" {
+		p.pos = start
+		return nil, p.errorf("missing FILTER")
+	}
+	sel, err := p.leaf(word)
+	if err != nil {
+		return nil, err
+	}
+	return sel, nil
+}
+
+// ParseSelector parses a selector expression. leaf parses each FILTER.
+func ParseSelector(s string, leaf func(filter string) (Selector, error)) (Selector, error) {
+	p := &selectorParser{s: s, leaf: leaf}
+	sel, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+	switch sel.(type) {
+	case *AndSelector, *OrSelector, *NotSelector:
+		return sel, nil
+	}
+	return NewAndSelector(sel), nil // So the result is always true or false.
+}
@@ -0,0 +1,93 @@
+package evutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+type namedDevice struct {
+	name   string
+	vendor uint16
+}
+
+func (d *namedDevice) Path() string                    { return "/dev/input/event0" }
+func (d *namedDevice) Name() (string, error)           { return d.name, nil }
+func (d *namedDevice) InputID() (evdev.InputID, error) { return evdev.InputID{Vendor: d.vendor}, nil }
+
+func testLeaf(filter string) (Selector, error) {
+	if key, value, ok := strings.Cut(filter, "="); ok {
+		return NewAttributeSelector(key, value)
+	}
+	return NewReSelector(filter), nil
+}
+
+func TestParseSelector(t *testing.T) {
+	devices := []*namedDevice{
+		{"Logitech USB Keyboard", 0x046d},
+		{"Logitech USB Receiver Mouse", 0x046d},
+		{"Corsair K70 Keyboard", 0x1b1c},
+		{"Corsair K70 Keyboard Consumer Control", 0x1b1c},
+		{"AT Translated Set 2 keyboard", 0x0001},
+	}
+	tests := []struct {
+		expr string
+		want string // The indexes of the matching devices.
+	}{
+		{`logitech & keyboard`, "0"},
+		{`(logitech & keyboard) | vendor=1b1c & !consumer`, "02"},
+		{`logitech&keyboard|corsair`, "023"},
+		{`logitech & (keyboard | mouse)`, "01"},
+		{`!(logitech | corsair)`, "4"},
+		{`!!mouse & logitech`, "1"},
+		{`AT Translated & keyboard`, "4"},
+		{`vendor=046d|0001 & !mouse`, "04"},
+		{`vendor=046d | corsair & consumer`, "013"},
+		{`'k(70|eyboard)$' & !logitech`, "24"},
+		{`( ( mouse ) )`, "1"},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.expr, testLeaf)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		got := ""
+		for i, d := range devices {
+			if Matches(sel, d) {
+				got += string(rune('0' + i))
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, expr := range []string{`a &`, `(a | b`, `a | | b`, `a) & b`, `'a & b`, `& a`, `vendor=xyz & a`} {
+		if _, err := ParseSelector(expr, testLeaf); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestIsSelectorExpr(t *testing.T) {
+	for s, want := range map[string]bool{
+		`logitech`:           false,
+		`!mouse`:             false,
+		`foo|bar`:            false,
+		`bus=usb|bluetooth`:  false,
+		`AT Translated`:      false,
+		`a & b`:              true,
+		`a | b`:              true,
+		`(a|b)c`:             true,
+		`!(a|b)`:             true,
+		`vendor=046d&!mouse`: true,
+	} {
+		if got := IsSelectorExpr(s); got != want {
+			t.Errorf("%s: got %v, want %v", s, got, want)
+		}
+	}
+}
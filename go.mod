@@ -3,8 +3,8 @@ module github.com/omakoto/evsniff-go
 go 1.23
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/deckarep/golang-set/v2 v2.7.0
-	github.com/jhenstridge/go-inotify v0.0.0-20221229091821-b0d1463614ad
 	github.com/maruel/natural v1.1.1
 	github.com/mattn/go-isatty v0.0.20
 	github.com/omakoto/go-common v0.0.0-20250201034257-33e3d2de7676